@@ -27,10 +27,94 @@ type CursorForward int
 type CursorBack int
 type CursorPosition Pos
 type CursorColumn int
+type CursorLine int
 type EraseDisplay EraseMode
 type EraseLine EraseMode
 type SaveCursorPosition struct{}
 type RestoreCursorPosition struct{}
+type SetAutowrap bool
+
+// Repeat prints the last printed character again, that many times.
+type Repeat int
+type SetCharset struct {
+	G       int
+	Charset Charset
+}
+type ShiftOut struct{}
+type ShiftIn struct{}
+type SetAlternateScreen struct {
+	Enabled bool
+	// SaveCursor also saves the cursor before switching to the alternate
+	// screen, and restores it when switching back ("\x1b[?1049h").
+	SaveCursor bool
+}
+
+// ReportStatus asks for a device status report ("\x1b[5n").
+type ReportStatus struct{}
+
+// ReportCursorPosition asks where the cursor is ("\x1b[6n").
+type ReportCursorPosition struct{}
+
+// ReportDeviceAttributes asks what kind of terminal this is, with "\x1b[c"
+// for the primary attributes or "\x1b[>c" for the secondary ones.
+type ReportDeviceAttributes struct {
+	Secondary bool
+}
+
+// ReportForeground asks for the default foreground colour ("\x1b]10;?\x07").
+type ReportForeground struct{}
+
+// ReportBackground asks for the default background colour ("\x1b]11;?\x07").
+type ReportBackground struct{}
+
+// ReportPaletteColor asks for a colour of the 256 colour palette
+// ("\x1b]4;n;?\x07").
+type ReportPaletteColor uint8
+
+// SetWindowTitle is set with "\x1b]2;title\x07", or "\x1b]0;title\x07"
+// which sets the icon name too.
+type SetWindowTitle string
+
+// SetIconName is set with "\x1b]1;name\x07".
+type SetIconName string
+
+// SetWorkingDirectory is reported by shells with "\x1b]7;file://host/path\x07".
+type SetWorkingDirectory string
+
+// CommandMark is a shell integration mark ("\x1b]133;A\x07"), which shells
+// print around the prompt, the command and its output.
+type CommandMark struct {
+	Kind CommandMarkKind
+	// ExitCode comes with CommandFinished, if the shell reported one.
+	ExitCode    int
+	HasExitCode bool
+}
+
+// OperatingSystemCommand is an OSC ("\x1b]code;args\x07") that isn't
+// understood by the Parser, passed on for whoever might know about it.
+type OperatingSystemCommand struct {
+	Code int
+	Args string
+}
+
+// InlineImage is an image sent with one of the inline image protocols.
+type InlineImage Image
+
+// Bell is BEL ("\x07").
+type Bell struct{}
+
+// Notify is a desktop notification, sent with "\x1b]9;body\x07" or
+// "\x1b]777;notify;title;body\x07".
+type Notify struct {
+	Title string
+	Body  string
+}
+
+// FullReset is RIS ("\x1bc").
+type FullReset struct{}
+
+// SoftReset is DECSTR ("\x1b[!p").
+type SoftReset struct{}
 
 type Pos struct {
 	Line int
@@ -34,10 +34,64 @@ func (a CursorPosition) ActionString() string { return "CursorPosition(" + Pos(a
 func (a CursorColumn) ActionString() string {
 	return "CursorColumn(" + strconv.FormatInt(int64(a), 10) + ")"
 }
+func (a CursorLine) ActionString() string {
+	return "CursorLine(" + strconv.FormatInt(int64(a), 10) + ")"
+}
 func (a EraseDisplay) ActionString() string          { return "EraseDisplay(" + EraseMode(a).String() + ")" }
 func (a EraseLine) ActionString() string             { return "EraseLine(" + EraseMode(a).String() + ")" }
 func (a SaveCursorPosition) ActionString() string    { return "SaveCursorPosition" }
 func (a RestoreCursorPosition) ActionString() string { return "RestoreCursorPosition" }
+func (a SetAutowrap) ActionString() string {
+	return "SetAutowrap(" + strconv.FormatBool(bool(a)) + ")"
+}
+func (a Repeat) ActionString() string { return "Repeat(" + strconv.FormatInt(int64(a), 10) + ")" }
+func (a SetCharset) ActionString() string {
+	return "SetCharset(G" + strconv.Itoa(a.G) + "," + a.Charset.String() + ")"
+}
+func (a ShiftOut) ActionString() string { return "ShiftOut" }
+func (a ShiftIn) ActionString() string  { return "ShiftIn" }
+func (a SetAlternateScreen) ActionString() string {
+	s := "SetAlternateScreen(" + strconv.FormatBool(a.Enabled)
+	if a.SaveCursor {
+		s += ",SaveCursor"
+	}
+	return s + ")"
+}
+func (a ReportStatus) ActionString() string         { return "ReportStatus" }
+func (a ReportCursorPosition) ActionString() string { return "ReportCursorPosition" }
+func (a ReportDeviceAttributes) ActionString() string {
+	if a.Secondary {
+		return "ReportDeviceAttributes(Secondary)"
+	}
+	return "ReportDeviceAttributes"
+}
+func (a ReportForeground) ActionString() string { return "ReportForeground" }
+func (a ReportBackground) ActionString() string { return "ReportBackground" }
+func (a ReportPaletteColor) ActionString() string {
+	return "ReportPaletteColor(" + strconv.Itoa(int(a)) + ")"
+}
+func (a SetWindowTitle) ActionString() string { return "SetWindowTitle(" + string(a) + ")" }
+func (a SetIconName) ActionString() string    { return "SetIconName(" + string(a) + ")" }
+func (a SetWorkingDirectory) ActionString() string {
+	return "SetWorkingDirectory(" + string(a) + ")"
+}
+func (a CommandMark) ActionString() string {
+	s := "CommandMark(" + string(a.Kind)
+	if a.HasExitCode {
+		s += "," + strconv.Itoa(a.ExitCode)
+	}
+	return s + ")"
+}
+func (a OperatingSystemCommand) ActionString() string {
+	return "OperatingSystemCommand(" + strconv.Itoa(a.Code) + "," + a.Args + ")"
+}
+func (a InlineImage) ActionString() string {
+	return "InlineImage(" + string(a.Protocol) + "," + a.Format + "," + strconv.Itoa(len(a.Data)) + ")"
+}
+func (a Bell) ActionString() string      { return "Bell" }
+func (a Notify) ActionString() string    { return "Notify(" + a.Title + "," + a.Body + ")" }
+func (a FullReset) ActionString() string { return "FullReset" }
+func (a SoftReset) ActionString() string { return "SoftReset" }
 
 func (a Print) String() string                 { return a.ActionString() }
 func (a Reset) String() string                 { return a.ActionString() }
@@ -59,10 +113,34 @@ func (a CursorForward) String() string         { return a.ActionString() }
 func (a CursorBack) String() string            { return a.ActionString() }
 func (a CursorPosition) String() string        { return a.ActionString() }
 func (a CursorColumn) String() string          { return a.ActionString() }
+func (a CursorLine) String() string            { return a.ActionString() }
 func (a EraseDisplay) String() string          { return a.ActionString() }
 func (a EraseLine) String() string             { return a.ActionString() }
 func (a SaveCursorPosition) String() string    { return a.ActionString() }
 func (a RestoreCursorPosition) String() string { return a.ActionString() }
+func (a SetAutowrap) String() string           { return a.ActionString() }
+func (a Repeat) String() string                { return a.ActionString() }
+func (a SetCharset) String() string            { return a.ActionString() }
+func (a ShiftOut) String() string              { return a.ActionString() }
+func (a ShiftIn) String() string               { return a.ActionString() }
+func (a SetAlternateScreen) String() string    { return a.ActionString() }
+
+func (a ReportStatus) String() string           { return a.ActionString() }
+func (a ReportCursorPosition) String() string   { return a.ActionString() }
+func (a ReportDeviceAttributes) String() string { return a.ActionString() }
+func (a ReportForeground) String() string       { return a.ActionString() }
+func (a ReportBackground) String() string       { return a.ActionString() }
+func (a ReportPaletteColor) String() string     { return a.ActionString() }
+func (a SetWindowTitle) String() string         { return a.ActionString() }
+func (a SetIconName) String() string            { return a.ActionString() }
+func (a SetWorkingDirectory) String() string    { return a.ActionString() }
+func (a CommandMark) String() string            { return a.ActionString() }
+func (a OperatingSystemCommand) String() string { return a.ActionString() }
+func (a InlineImage) String() string            { return a.ActionString() }
+func (a Bell) String() string                   { return a.ActionString() }
+func (a Notify) String() string                 { return a.ActionString() }
+func (a FullReset) String() string              { return a.ActionString() }
+func (a SoftReset) String() string              { return a.ActionString() }
 
 func (p Pos) String() string {
 	return "L" + strconv.FormatInt(int64(p.Line), 10) + "C" + strconv.FormatInt(int64(p.Col), 10)
@@ -76,3 +154,11 @@ var eraseModeNames = [4]string{
 }
 
 func (e EraseMode) String() string { return eraseModeNames[e] }
+
+var charsetNames = [...]string{
+	"ASCII",
+	"UK",
+	"DECSpecialGraphics",
+}
+
+func (c Charset) String() string { return charsetNames[c] }
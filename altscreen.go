@@ -0,0 +1,177 @@
+package ansi
+
+// AltScreenPolicy decides what happens to what was drawn on the alternate
+// screen once the program using it switches back to the main screen.
+type AltScreenPolicy int
+
+const (
+	// DiscardAltScreen drops the alternate screen, like a terminal does.
+	DiscardAltScreen AltScreenPolicy = iota
+	// SnapshotAltScreen keeps its final frame in Writer.AltScreenSnapshots,
+	// without trailing empty lines.
+	SnapshotAltScreen
+	// AppendAltScreen prints its final frame into the main screen's Output
+	// at the cursor, as if it had been printed there.
+	AppendAltScreen
+)
+
+// mainScreen is what gets put aside while the alternate screen is active.
+type mainScreen struct {
+	output      Output
+	position    Pos
+	maxLine     int
+	reached     int
+	pendingWrap bool
+	savedCursor *SavedCursor
+}
+
+func (w *Writer) enterAltScreen(saveCursor bool) {
+	if w.AltScreen {
+		return
+	}
+	if saveCursor {
+		w.Action(SaveCursorPosition{})
+	}
+	w.main = &mainScreen{
+		output:      w.Output,
+		position:    w.Position,
+		maxLine:     w.MaxLine,
+		reached:     w.reached,
+		pendingWrap: w.PendingWrap,
+		savedCursor: w.SavedCursor,
+	}
+
+	w.AltScreen = true
+	w.alt = Lines{}
+	w.Output = &w.alt
+	w.setSavedCursor(w.altSavedCursor)
+
+	// The cursor stays where it was on the screen, which starts Height
+	// lines above the bottom of the main screen
+	if top := w.MaxLine - w.Height; top > 0 {
+		w.Position.Line -= top
+	}
+	w.MaxLine = w.Height
+	w.reached = 0
+	w.moveCursorTo(w.Position.Line, w.Position.Col)
+}
+
+// exitAltScreen switches back to the main screen, dealing with what was
+// drawn on the alternate one according to policy.
+func (w *Writer) exitAltScreen(restoreCursor bool, policy AltScreenPolicy) error {
+	if !w.AltScreen {
+		return nil
+	}
+	main := w.main
+	w.AltScreen = false
+	w.main = nil
+	w.altSavedCursor = w.SavedCursor
+
+	w.Output = main.output
+	w.Position = main.position
+	w.MaxLine = main.maxLine
+	w.reached = main.reached
+	w.PendingWrap = main.pendingWrap
+	w.setSavedCursor(main.savedCursor)
+	if restoreCursor {
+		w.Action(RestoreCursorPosition{})
+	}
+
+	frame := w.alt
+	w.alt = nil
+	for len(frame) > 0 && len(frame[len(frame)-1]) == 0 {
+		frame = frame[:len(frame)-1]
+	}
+	switch policy {
+	case SnapshotAltScreen:
+		w.AltScreenSnapshots = append(w.AltScreenSnapshots, frame)
+	case AppendAltScreen:
+		return w.appendFrame(frame)
+	}
+	return nil
+}
+
+// swapMain swaps the screen in use with the main screen put aside while the
+// alternate screen is active, so that it can be worked on like the active
+// one. Calling it again swaps them back.
+func (w *Writer) swapMain() {
+	m := w.main
+	w.Output, m.output = m.output, w.Output
+	w.Position, m.position = m.position, w.Position
+	w.MaxLine, m.maxLine = m.maxLine, w.MaxLine
+	w.reached, m.reached = m.reached, w.reached
+	w.PendingWrap, m.pendingWrap = m.pendingWrap, w.PendingWrap
+	saved := w.SavedCursor
+	w.setSavedCursor(m.savedCursor)
+	m.savedCursor = saved
+	w.AltScreen = !w.AltScreen
+}
+
+// mainLine is the line the cursor is on in the main screen, even while the
+// alternate screen is active.
+func (w *Writer) mainLine() int {
+	if w.AltScreen {
+		return w.main.position.Line
+	}
+	return w.Position.Line
+}
+
+// appendFrame prints frame on the lines starting at the cursor, or below it
+// if the cursor isn't at the start of a line, leaving the cursor right after.
+func (w *Writer) appendFrame(frame Lines) error {
+	if len(frame) == 0 {
+		return nil
+	}
+
+	if w.Position.Col > 0 || w.PendingWrap {
+		w.lineFeed()
+	}
+	for _, line := range frame {
+		col := 0
+		for _, chunk := range line {
+			pos := Pos{Line: w.Position.Line, Col: col}
+			if err := w.outputChunk(chunk, pos); err != nil {
+				return err
+			}
+			col += textWidth(chunk.Data)
+		}
+		w.touch(w.Position.Line)
+		w.lineFeed()
+	}
+	w.Position.Col = 0
+	return nil
+}
+
+// eraseDisplay clears the alternate screen. The main screen is a log of
+// everything that was output, so nothing gets erased from it.
+func (w *Writer) eraseDisplay(mode EraseMode) error {
+	if !w.AltScreen {
+		return nil
+	}
+	from, to := 0, w.MaxLine
+	switch mode {
+	case EraseToEnd:
+		if err := w.clearRight(w.Position); err != nil {
+			return err
+		}
+		from = w.Position.Line + 1
+	case EraseToBeginning:
+		if err := w.Action(EraseLine(EraseToBeginning)); err != nil {
+			return err
+		}
+		to = w.Position.Line - 1
+	}
+	for line := from; line <= to; line++ {
+		if err := w.clearRight(Pos{Line: line}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WithAltScreenPolicy decides what is kept of the alternate screen.
+func WithAltScreenPolicy(policy AltScreenPolicy) WriterOption {
+	return func(w *Writer) {
+		w.AltScreenPolicy = policy
+	}
+}
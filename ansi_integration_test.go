@@ -14,6 +14,7 @@ import (
 func TestAnsi_Integration_Lines(t *testing.T) {
 	for _, tt := range []struct {
 		description string
+		opts        []ansi.WriterOption
 		events      [][]byte
 		lines       ansi.Lines
 	}{
@@ -119,6 +120,25 @@ func TestAnsi_Integration_Lines(t *testing.T) {
 				},
 			},
 		},
+		{
+			description: "line drawing",
+			events: [][]byte{
+				[]byte("\x1b(0lqqk\x1b(B\n"),
+				[]byte("\x1b(0x\x1b(B  \x1b(0x\x1b(B\n"),
+			},
+			lines: ansi.Lines{
+				{
+					{
+						Data: ansi.Text("┌──┐"),
+					},
+				},
+				{
+					{
+						Data: ansi.Text("│  │"),
+					},
+				},
+			},
+		},
 		{
 			description: "erase line",
 			events: [][]byte{
@@ -133,12 +153,108 @@ func TestAnsi_Integration_Lines(t *testing.T) {
 				},
 			},
 		},
+		{
+			description: "autowrap at a fixed width",
+			opts:        []ansi.WriterOption{ansi.WithFixedWidth(10)},
+			events: [][]byte{
+				[]byte("0123456789\x1b[1mabc\x1b[m\n"),
+				[]byte("\x1b[?7lexactly 10\n0123456789abc"),
+			},
+			lines: ansi.Lines{
+				{
+					{
+						Data:    ansi.Text("0123456789"),
+						Wrapped: true,
+					},
+				},
+				{
+					{
+						Data:  ansi.Text("abc"),
+						Style: ansi.Style{Modifier: ansi.Bold},
+					},
+				},
+				{
+					{
+						Data: ansi.Text("exactly 10"),
+					},
+				},
+				{
+					{
+						Data: ansi.Text("012345678c"),
+					},
+				},
+			},
+		},
+		{
+			description: "background colour erase paints coloured bars",
+			opts: []ansi.WriterOption{
+				ansi.WithFixedWidth(10),
+				ansi.WithBackgroundColorErase(),
+			},
+			events: [][]byte{
+				[]byte("\x1b[42m\x1b[2K PASSED\x1b[m\n"),
+				[]byte("\x1b[2Kplain\x1b[K\n"),
+			},
+			lines: ansi.Lines{
+				{
+					{
+						Data:  ansi.Text(" PASSED   "),
+						Style: ansi.Style{Background: ansi.Green},
+					},
+				},
+				{
+					{
+						Data: ansi.Text("plain"),
+					},
+				},
+			},
+		},
+		{
+			description: "inline images leave a placeholder",
+			events: [][]byte{
+				[]byte("ab\x1b]1337;File=inline=1:iVBORw0KGgo=\x07c\n"),
+				[]byte("\x1b[1mx\x1b]1337;File=inline=1:iVBORw0KGgo=\x07\x1b[1D\x1b]1337;File=inline=1:aGk=\x07x"),
+			},
+			lines: ansi.Lines{
+				{
+					{Data: ansi.Text("ab")},
+					{
+						Data:  ansi.Text("\uFFFC"),
+						Image: &ansi.Image{Protocol: ansi.ITermImages, Format: "image/png", Data: []byte("\x89PNG\r\n\x1a\n")},
+					},
+					{Data: ansi.Text("c")},
+				},
+				{
+					{Data: ansi.Text("x"), Style: ansi.Style{Modifier: ansi.Bold}},
+					{
+						Data:  ansi.Text("\uFFFC"),
+						Style: ansi.Style{Modifier: ansi.Bold},
+						Image: &ansi.Image{Protocol: ansi.ITermImages, Data: []byte("hi")},
+					},
+					{Data: ansi.Text("x"), Style: ansi.Style{Modifier: ansi.Bold}},
+				},
+			},
+		},
+		{
+			description: "moving the cursor over wide characters",
+			events: [][]byte{
+				[]byte("┌─ 進捗 ─┐\n"),
+				[]byte("\x1b[1A\x1b[4C状況"),
+			},
+			lines: ansi.Lines{
+				{
+					{
+						Data: ansi.Text("┌─  状況─┐"),
+					},
+				},
+			},
+		},
 	} {
 		t.Run(tt.description, func(t *testing.T) {
 			g := NewGomegaWithT(t)
 
 			var lines ansi.Lines
-			writer := ansi.NewWriter(&lines)
+			writer := ansi.NewWriter(&lines, tt.opts...)
 
 			initialEvents := make([][]byte, len(tt.events))
 			for i, evt := range tt.events {
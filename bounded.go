@@ -0,0 +1,134 @@
+package ansi
+
+// BoundedLines is an Output like Lines that holds on to at most MaxLines
+// lines, or MaxBytes bytes of text, evicting the oldest ones once there are
+// more. Positions are still absolute line numbers, Lines[0] being line
+// Offset, so that it can be written to like Lines. Whatever is printed to an
+// evicted line is dropped.
+type BoundedLines struct {
+	// MaxLines and MaxBytes are the limits, either is ignored when <= 0. The
+	// last line is kept whatever its size.
+	MaxLines int
+	MaxBytes int
+	// Spill, if set, is given every line that gets evicted, along with its
+	// line number.
+	Spill func(line int, l Line)
+
+	Lines  Lines
+	Offset int
+
+	bytes int
+}
+
+func (b *BoundedLines) Print(data []byte, style Style, pos Pos) error {
+	if pos.Line < 0 {
+		pos.Line = 0
+	}
+	return b.update(pos.Line, func(line int) error {
+		pos.Line = line
+		return b.Lines.Print(data, style, pos)
+	})
+}
+
+func (b *BoundedLines) ClearRight(pos Pos) error {
+	return b.update(pos.Line, func(line int) error {
+		pos.Line = line
+		return b.Lines.ClearRight(pos)
+	})
+}
+
+func (b *BoundedLines) PrintChunk(chunk Chunk, pos Pos) error {
+	if pos.Line < 0 {
+		pos.Line = 0
+	}
+	return b.update(pos.Line, func(line int) error {
+		pos.Line = line
+		return b.Lines.PrintChunk(chunk, pos)
+	})
+}
+
+func (b *BoundedLines) MarkWrapped(line int) error {
+	return b.update(line, b.Lines.MarkWrapped)
+}
+
+func (b *BoundedLines) PrintImage(image *Image, style Style, pos Pos) error {
+	if pos.Line < 0 {
+		pos.Line = 0
+	}
+	return b.update(pos.Line, func(line int) error {
+		pos.Line = line
+		return b.Lines.PrintImage(image, style, pos)
+	})
+}
+
+// Reflow rewraps the lines still held. The first of them may be the rest of
+// an evicted line that was soft-wrapped, and gets rewrapped on its own.
+func (b *BoundedLines) Reflow(cols int, positions ...Pos) ([]Pos, error) {
+	relative := make([]Pos, len(positions))
+	for i, pos := range positions {
+		relative[i] = Pos{Line: pos.Line - b.Offset, Col: pos.Col}
+	}
+	newPositions, err := b.Lines.Reflow(cols, relative...)
+	for i := range newPositions {
+		newPositions[i].Line += b.Offset
+	}
+	if err != nil {
+		return newPositions, err
+	}
+	b.bytes = 0
+	for _, line := range b.Lines {
+		b.bytes += lineBytes(line)
+	}
+	b.evict()
+	return newPositions, nil
+}
+
+// End is the line after the last one, whether it's been evicted or not.
+func (b *BoundedLines) End() int {
+	return b.Offset + len(b.Lines)
+}
+
+// update makes a change to line, given to change relative to Lines, keeping
+// track of its size and evicting lines if needed.
+func (b *BoundedLines) update(line int, change func(line int) error) error {
+	line -= b.Offset
+	if line < 0 {
+		return nil
+	}
+	before := 0
+	if line < len(b.Lines) {
+		before = lineBytes(b.Lines[line])
+	}
+	if err := change(line); err != nil {
+		return err
+	}
+	if line < len(b.Lines) {
+		b.bytes += lineBytes(b.Lines[line]) - before
+	}
+	b.evict()
+	return nil
+}
+
+func (b *BoundedLines) evict() {
+	for len(b.Lines) > 1 &&
+		(b.MaxLines > 0 && len(b.Lines) > b.MaxLines || b.MaxBytes > 0 && b.bytes > b.MaxBytes) {
+		line := b.Lines[0]
+		b.bytes -= lineBytes(line)
+		// Don't keep the evicted line alive through the backing array
+		b.Lines[0] = nil
+		b.Lines = b.Lines[1:]
+		if b.Spill != nil {
+			b.Spill(b.Offset, line)
+		}
+		b.Offset++
+	}
+}
+
+// lineBytes is how much text line holds.
+func lineBytes(line Line) int {
+	n := 0
+	for _, chunk := range line {
+		n += len(chunk.Data)
+	}
+	return n
+}
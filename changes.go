@@ -0,0 +1,185 @@
+package ansi
+
+import "sort"
+
+// ChangeLog is an Output that keeps Lines along with a record of which of
+// them changed in each version, so that a copy of them can be brought up to
+// date with Changes rather than sent again in full.
+type ChangeLog struct {
+	Lines Lines
+
+	version int
+	records []changeRecord
+}
+
+// Change is a patch to a copy of Lines: Line is replaced with Chunks, adding
+// empty lines before it if there aren't enough, unless Truncate is set, in
+// which case Line and every line after it are dropped.
+type Change struct {
+	Line     int  `json:"line"`
+	Chunks   Line `json:"chunks,omitempty"`
+	Truncate bool `json:"truncate,omitempty"`
+}
+
+// ChangeSet brings a copy of Lines up to Version once its Changes are
+// applied in order.
+type ChangeSet struct {
+	Version int      `json:"version"`
+	Changes []Change `json:"changes"`
+}
+
+// changeRecord is a line changing, or the lines from it on being dropped,
+// in version.
+type changeRecord struct {
+	version  int
+	line     int
+	truncate bool
+}
+
+// Version is increased by every change to Lines.
+func (c *ChangeLog) Version() int {
+	return c.version
+}
+
+// Changes is what changed since version, with each line that changed sent
+// as it is now, once.
+func (c *ChangeLog) Changes(since int) ChangeSet {
+	set := ChangeSet{Version: c.version, Changes: []Change{}}
+	first := sort.Search(len(c.records), func(i int) bool {
+		return c.records[i].version > since
+	})
+
+	truncate := -1
+	changed := make(map[int]bool)
+	for _, record := range c.records[first:] {
+		switch {
+		case record.truncate:
+			if truncate < 0 || record.line < truncate {
+				truncate = record.line
+			}
+		case record.line < len(c.Lines):
+			changed[record.line] = true
+		}
+	}
+	if truncate >= 0 {
+		set.Changes = append(set.Changes, Change{Line: truncate, Truncate: true})
+	}
+
+	lines := make([]int, 0, len(changed))
+	for line := range changed {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	for _, line := range lines {
+		set.Changes = append(set.Changes, Change{Line: line, Chunks: copyLine(c.Lines[line])})
+	}
+	return set
+}
+
+func (c *ChangeLog) Print(data []byte, style Style, pos Pos) error {
+	if err := c.Lines.Print(data, style, pos); err != nil {
+		return err
+	}
+	c.changed(pos.Line)
+	return nil
+}
+
+func (c *ChangeLog) PrintChunk(chunk Chunk, pos Pos) error {
+	if err := c.Lines.PrintChunk(chunk, pos); err != nil {
+		return err
+	}
+	c.changed(pos.Line)
+	return nil
+}
+
+func (c *ChangeLog) ClearRight(pos Pos) error {
+	if pos.Line < 0 || pos.Line >= len(c.Lines) {
+		return nil
+	}
+	if err := c.Lines.ClearRight(pos); err != nil {
+		return err
+	}
+	c.changed(pos.Line)
+	return nil
+}
+
+func (c *ChangeLog) MarkWrapped(line int) error {
+	if line < 0 || line >= len(c.Lines) {
+		return nil
+	}
+	if err := c.Lines.MarkWrapped(line); err != nil {
+		return err
+	}
+	c.changed(line)
+	return nil
+}
+
+func (c *ChangeLog) PrintImage(image *Image, style Style, pos Pos) error {
+	if err := c.Lines.PrintImage(image, style, pos); err != nil {
+		return err
+	}
+	c.changed(pos.Line)
+	return nil
+}
+
+// Reflow rewraps Lines, which changes every one of them.
+func (c *ChangeLog) Reflow(cols int, positions ...Pos) ([]Pos, error) {
+	before := len(c.Lines)
+	newPositions, err := c.Lines.Reflow(cols, positions...)
+	if err != nil {
+		return newPositions, err
+	}
+	c.version++
+	if len(c.Lines) < before {
+		c.records = append(c.records, changeRecord{version: c.version, line: len(c.Lines), truncate: true})
+	}
+	for line := range c.Lines {
+		c.records = append(c.records, changeRecord{version: c.version, line: line})
+	}
+	return newPositions, nil
+}
+
+// changed records line as changed in a new version. Lines keep changing
+// while they're being printed to, so the record of the last change is
+// reused when it's for the same line.
+func (c *ChangeLog) changed(line int) {
+	if line < 0 {
+		line = 0
+	}
+	c.version++
+	if n := len(c.records); n > 0 {
+		last := &c.records[n-1]
+		if last.line == line && !last.truncate {
+			last.version = c.version
+			return
+		}
+	}
+	c.records = append(c.records, changeRecord{version: c.version, line: line})
+}
+
+// Apply brings l up to date with changes.
+func (l *Lines) Apply(changes []Change) {
+	for _, change := range changes {
+		if change.Truncate {
+			if change.Line < len(*l) {
+				*l = (*l)[:change.Line]
+			}
+			continue
+		}
+		for len(*l) <= change.Line {
+			*l = append(*l, Line{})
+		}
+		(*l)[change.Line] = copyLine(change.Chunks)
+	}
+}
+
+// copyLine copies line along with the data of its chunks, which Lines may
+// write over in place.
+func copyLine(line Line) Line {
+	newLine := make(Line, len(line))
+	for i, chunk := range line {
+		chunk.Data = copyBytes(chunk.Data)
+		newLine[i] = chunk
+	}
+	return newLine
+}
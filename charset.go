@@ -0,0 +1,82 @@
+package ansi
+
+import "unicode/utf8"
+
+// Charset is a character set that can be designated into G0 or G1 with
+// "\x1b(" and "\x1b)", see https://vt100.net/docs/vt100-ug/chapter3.html#SCS
+type Charset uint8
+
+const (
+	CharsetASCII Charset = iota
+	CharsetUK
+	CharsetDECSpecialGraphics
+)
+
+var charsetDesignations = map[byte]Charset{
+	'B': CharsetASCII,
+	'A': CharsetUK,
+	'0': CharsetDECSpecialGraphics,
+}
+
+// Charsets is the G0 and G1 designations, and which one is active.
+type Charsets struct {
+	G        [2]Charset
+	ShiftOut bool
+}
+
+func (c Charsets) active() Charset {
+	if c.ShiftOut {
+		return c.G[1]
+	}
+	return c.G[0]
+}
+
+// decSpecialGraphics maps 0x5f-0x7e to the line drawing characters they
+// stand for in the DEC Special Graphics set.
+var decSpecialGraphics = [...]rune{
+	' ', '◆', '▒', '␉', '␌', '␍', '␊', '°', '±', '␤', '␋', '┘', '┐', '┌', '└', '┼',
+	'⎺', '⎻', '─', '⎼', '⎽', '├', '┤', '┴', '┬', '│', '≤', '≥', 'π', '≠', '£', '·',
+}
+
+// translate rewrites data into what it stands for in charset. It returns
+// data itself when nothing needs translating.
+func (c Charset) translate(data []byte) []byte {
+	var lookup func(b byte) (rune, bool)
+	switch c {
+	case CharsetUK:
+		lookup = func(b byte) (rune, bool) {
+			return '£', b == '#'
+		}
+	case CharsetDECSpecialGraphics:
+		lookup = func(b byte) (rune, bool) {
+			if b < 0x5f || b > 0x7e {
+				return 0, false
+			}
+			return decSpecialGraphics[b-0x5f], true
+		}
+	default:
+		return data
+	}
+
+	var out []byte
+	for i, b := range data {
+		r, ok := lookup(b)
+		if !ok {
+			if out != nil {
+				out = append(out, b)
+			}
+			continue
+		}
+		if out == nil {
+			out = make([]byte, i, len(data)+2*(len(data)-i))
+			copy(out, data[:i])
+		}
+		var buf [utf8.UTFMax]byte
+		n := utf8.EncodeRune(buf[:], r)
+		out = append(out, buf[:n]...)
+	}
+	if out == nil {
+		return data
+	}
+	return out
+}
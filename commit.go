@@ -0,0 +1,58 @@
+package ansi
+
+// CommitOutput is implemented by Outputs that want to know once a line of
+// the main screen is final, e.g. to persist it. Lines are committed once, in
+// order, as soon as they've scrolled off the top of the screen, where the
+// cursor can't reach them anymore, and all remaining ones on Close.
+//
+// Reflowing on Resize still rewraps every line, committed or not, which
+// renumbers them. Lines that end up holding committed text count as
+// committed, so their new numbers are skipped rather than committed again.
+type CommitOutput interface {
+	CommitLine(line int) error
+}
+
+// firstLine is the first line the cursor can be moved to: the first one that
+// isn't committed on the main screen.
+func (w *Writer) firstLine() int {
+	if w.AltScreen {
+		return 0
+	}
+	return w.committed
+}
+
+// screenTop is the first line of the screen the cursor can reach.
+func (w *Writer) screenTop() int {
+	top := w.MaxLine - w.Height
+	if top < w.firstLine() {
+		top = w.firstLine()
+	}
+	return top
+}
+
+// commitLines commits the lines of the main screen before end that haven't
+// been yet.
+func (w *Writer) commitLines(end int) error {
+	output := w.Output
+	if w.AltScreen {
+		output = w.main.output
+	}
+	out, ok := output.(CommitOutput)
+	if !ok {
+		return nil
+	}
+	for w.committed < end {
+		if err := out.CommitLine(w.committed); err != nil {
+			return err
+		}
+		w.committed++
+	}
+	return nil
+}
+
+// touch records that line of the main screen was printed to.
+func (w *Writer) touch(line int) {
+	if !w.AltScreen && line >= w.printedLines {
+		w.printedLines = line + 1
+	}
+}
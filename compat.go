@@ -0,0 +1,52 @@
+package ansi
+
+// Compatibility selects whose quirks the Parser follows where
+// implementations disagree on how a sequence should be read.
+type Compatibility int
+
+const (
+	// ElmANSI matches vito/elm-ansi, which logs have historically been
+	// rendered with: cursor positions are used as-is instead of being
+	// converted from 1-based, "\x1b[G" goes to column 0, and a trailing empty
+	// SGR parameter doesn't reset like iTerm.
+	ElmANSI Compatibility = iota
+	// ECMA48 sticks to ECMA-48 and the VT100: positions are 1-based, empty
+	// and zero parameters take their default, and xterm extensions such as
+	// the alternate screen and "\x1b[s" are ignored.
+	ECMA48
+	// XTerm reads parameters like ECMA48, but understands xterm's
+	// extensions too.
+	XTerm
+)
+
+// position converts a 1-based position parameter into a 0-based index.
+// ElmANSI uses the parameter as-is, defaulting to legacyDefault.
+func (c Compatibility) position(n maybeInt, legacyDefault int) int {
+	if c == ElmANSI {
+		return n.withDefault(legacyDefault)
+	}
+	return c.count(n) - 1
+}
+
+// count reads a parameter that counts something, where 0 means the default
+// of 1 for everyone but ElmANSI.
+func (c Compatibility) count(n maybeInt) int {
+	v := n.withDefault(1)
+	if c != ElmANSI && v < 1 {
+		return 1
+	}
+	return v
+}
+
+// extensions is whether sequences that only xterm and its descendants know
+// about are understood.
+func (c Compatibility) extensions() bool {
+	return c != ECMA48
+}
+
+// WithCompatibility sets whose quirks the Writer's Parser follows.
+func WithCompatibility(c Compatibility) WriterOption {
+	return func(w *Writer) {
+		w.Parser.Compatibility = c
+	}
+}
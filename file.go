@@ -0,0 +1,218 @@
+package ansi
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// FileLines is an Output that only keeps the lines that can still change in
+// memory, and writes the others out once the Writer commits them (see
+// CommitOutput): each line is appended to Data, and where it ends in Data to
+// Index, so that ranges of lines can be read back with ReadFileLines
+// without reading all of Data.
+//
+// Data holds a line of JSON per line of output. Index holds a big-endian
+// uint64 per line.
+type FileLines struct {
+	Data  io.Writer
+	Index io.Writer
+
+	recent windowLines
+	size   uint64
+}
+
+// fileChunk is how a Chunk is written out. Unlike a Chunk's own JSON, it
+// keeps colours as numbers, since their names can't always be read back.
+type fileChunk struct {
+	Data       Text          `json:"d"`
+	Foreground uint32        `json:"f,omitempty"`
+	Background uint32        `json:"b,omitempty"`
+	Modifier   StyleModifier `json:"m,omitempty"`
+	Stream     string        `json:"s,omitempty"`
+	Time       int64         `json:"t,omitempty"`
+	Wrapped    bool          `json:"w,omitempty"`
+	Image      *Image        `json:"i,omitempty"`
+}
+
+func NewFileLines(data, index io.Writer) *FileLines {
+	return &FileLines{Data: data, Index: index}
+}
+
+func (f *FileLines) Print(data []byte, style Style, pos Pos) error {
+	return f.recent.Print(data, style, pos)
+}
+
+func (f *FileLines) PrintChunk(chunk Chunk, pos Pos) error {
+	return f.recent.PrintChunk(chunk, pos)
+}
+
+func (f *FileLines) ClearRight(pos Pos) error {
+	return f.recent.ClearRight(pos)
+}
+
+func (f *FileLines) MarkWrapped(line int) error {
+	return f.recent.MarkWrapped(line)
+}
+
+func (f *FileLines) PrintImage(image *Image, style Style, pos Pos) error {
+	return f.recent.PrintImage(image, style, pos)
+}
+
+// CommitLine writes line out, and lets go of it.
+func (f *FileLines) CommitLine(line int) error {
+	if line < f.recent.offset {
+		return nil
+	}
+	var l Line
+	if i := line - f.recent.offset; i < len(f.recent.lines) {
+		l = f.recent.lines[i]
+	}
+
+	encoded, err := encodeFileLine(l)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Data.Write(encoded); err != nil {
+		return err
+	}
+	f.size += uint64(len(encoded))
+	var end [8]byte
+	binary.BigEndian.PutUint64(end[:], f.size)
+	if _, err := f.Index.Write(end[:]); err != nil {
+		return err
+	}
+	f.recent.release(line + 1)
+	return nil
+}
+
+// Committed is how many lines were written out.
+func (f *FileLines) Committed() int {
+	return f.recent.offset
+}
+
+// Recent is the lines that weren't written out yet, the first one being
+// line Committed.
+func (f *FileLines) Recent() Lines {
+	return f.recent.lines
+}
+
+// ReadFileLines reads the lines from line from up to line to out of what a
+// FileLines wrote to data and index. There are fewer of them if the files
+// end sooner.
+func ReadFileLines(data, index io.ReaderAt, from, to int) (Lines, error) {
+	if from < 0 {
+		from = 0
+	}
+	if to <= from {
+		return Lines{}, nil
+	}
+
+	// The line before from ends where from starts
+	first := int64(from) - 1
+	if first < 0 {
+		first = 0
+	}
+	entries := bufio.NewReader(io.NewSectionReader(index, first*8, int64(to)*8-first*8))
+	var start uint64
+	if from > 0 {
+		if err := binary.Read(entries, binary.BigEndian, &start); err != nil {
+			if err == io.EOF {
+				return Lines{}, nil
+			}
+			return nil, err
+		}
+	}
+	var ends []uint64
+	for len(ends) < to-from {
+		var end uint64
+		if err := binary.Read(entries, binary.BigEndian, &end); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		ends = append(ends, end)
+	}
+	if len(ends) == 0 {
+		return Lines{}, nil
+	}
+
+	buf := make([]byte, ends[len(ends)-1]-start)
+	if _, err := data.ReadAt(buf, int64(start)); err != nil && err != io.EOF {
+		return nil, err
+	}
+	lines := make(Lines, len(ends))
+	for i, end := range ends {
+		encoded := buf[:end-start]
+		buf, start = buf[end-start:], end
+		line, err := decodeFileLine(encoded)
+		if err != nil {
+			return nil, err
+		}
+		lines[i] = line
+	}
+	return lines, nil
+}
+
+func encodeFileLine(line Line) ([]byte, error) {
+	chunks := make([]fileChunk, len(line))
+	for i, chunk := range line {
+		chunks[i] = fileChunk{
+			Data:       chunk.Data,
+			Foreground: uint32(chunk.Style.Foreground),
+			Background: uint32(chunk.Style.Background),
+			Modifier:   chunk.Style.Modifier,
+			Stream:     chunk.Stream,
+			Time:       unixNano(chunk.Time),
+			Wrapped:    chunk.Wrapped,
+			Image:      chunk.Image,
+		}
+	}
+	encoded, err := json.Marshal(chunks)
+	if err != nil {
+		return nil, err
+	}
+	return append(encoded, '\n'), nil
+}
+
+func decodeFileLine(encoded []byte) (Line, error) {
+	var chunks []fileChunk
+	if err := json.Unmarshal(encoded, &chunks); err != nil {
+		return nil, err
+	}
+	line := make(Line, len(chunks))
+	for i, chunk := range chunks {
+		line[i] = Chunk{
+			Data: chunk.Data,
+			Style: Style{
+				Foreground: Color(chunk.Foreground),
+				Background: Color(chunk.Background),
+				Modifier:   chunk.Modifier,
+			},
+			Wrapped: chunk.Wrapped,
+			Image:   chunk.Image,
+			Stream:  chunk.Stream,
+			Time:    fromUnixNano(chunk.Time),
+		}
+	}
+	return line, nil
+}
+
+// unixNano is t as a number, 0 when it's unset.
+func unixNano(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) *time.Time {
+	if n == 0 {
+		return nil
+	}
+	t := time.Unix(0, n).UTC()
+	return &t
+}
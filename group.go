@@ -0,0 +1,164 @@
+package ansi
+
+import "regexp"
+
+// GroupDetector recognises the lines that start and end a group of lines,
+// such as "::group::Title" and "::endgroup::".
+type GroupDetector interface {
+	// DetectGroup is given the text of a line once it's ended.
+	DetectGroup(line []byte) (GroupMarker, bool)
+}
+
+// OSCGroupDetector is implemented by GroupDetectors that recognise
+// operating system commands as markers too.
+type OSCGroupDetector interface {
+	DetectGroupOSC(cmd OperatingSystemCommand) (GroupMarker, bool)
+}
+
+// GroupMarker starts a group with Title, or ends the innermost open group.
+type GroupMarker struct {
+	End   bool
+	Title string
+}
+
+// PatternGroupDetector recognises lines matching Start or End. The title is
+// Start's first subexpression, or the whole match if it has none. With OSC
+// set, the arguments of that OSC are matched the same way.
+type PatternGroupDetector struct {
+	Start *regexp.Regexp
+	End   *regexp.Regexp
+	OSC   int
+}
+
+// GitHubGroups recognises GitHub Actions' workflow commands.
+var GitHubGroups = PatternGroupDetector{
+	Start: regexp.MustCompile(`^::group::(.*)$`),
+	End:   regexp.MustCompile(`^::endgroup::$`),
+}
+
+func (d PatternGroupDetector) DetectGroup(line []byte) (GroupMarker, bool) {
+	if d.End != nil && d.End.Match(line) {
+		return GroupMarker{End: true}, true
+	}
+	if d.Start == nil {
+		return GroupMarker{}, false
+	}
+	match := d.Start.FindSubmatch(line)
+	if match == nil {
+		return GroupMarker{}, false
+	}
+	title := match[0]
+	if len(match) > 1 {
+		title = match[1]
+	}
+	return GroupMarker{Title: string(title)}, true
+}
+
+func (d PatternGroupDetector) DetectGroupOSC(cmd OperatingSystemCommand) (GroupMarker, bool) {
+	if d.OSC == 0 || cmd.Code != d.OSC {
+		return GroupMarker{}, false
+	}
+	return d.DetectGroup([]byte(cmd.Args))
+}
+
+// Group is a range of lines of the main screen between two markers, which
+// are stripped from the output. EndLine is the first line after the group,
+// and isn't set while the group is still Open.
+type Group struct {
+	Title     string   `json:"title"`
+	StartLine int      `json:"start"`
+	EndLine   int      `json:"end"`
+	Open      bool     `json:"open,omitempty"`
+	Groups    []*Group `json:"groups,omitempty"`
+}
+
+// GroupedLines is Lines along with the groups found in them, for
+// serializing them together.
+type GroupedLines struct {
+	Lines  Lines    `json:"lines"`
+	Groups []*Group `json:"groups,omitempty"`
+}
+
+// groupLine keeps the text of the line being printed, for as long as it
+// could still be a marker: printed in one go from the start of the line.
+type groupLine struct {
+	text  []byte
+	end   Pos
+	valid bool
+}
+
+func (w *Writer) trackGroupLine(data []byte) {
+	if w.GroupDetector == nil || w.AltScreen {
+		return
+	}
+	l := &w.groupLine
+	switch {
+	case w.Position.Col == 0 && !w.PendingWrap && len(l.text) == 0:
+		l.valid = true
+	case !l.valid || w.Position != l.end || w.PendingWrap:
+		l.valid = false
+		return
+	}
+	l.text = append(l.text, data...)
+	l.end = Pos{Line: w.Position.Line, Col: w.Position.Col + textWidth(data)}
+}
+
+// endGroupLine strips the line that was just ended from the output if it's
+// a marker, leaving the cursor at its start rather than on the next line.
+func (w *Writer) endGroupLine() (bool, error) {
+	l := &w.groupLine
+	text, valid := l.text, l.valid && l.end.Line == w.Position.Line
+	l.text, l.valid = l.text[:0], false
+	if !valid || w.GroupDetector == nil || w.AltScreen {
+		return false, nil
+	}
+	marker, ok := w.GroupDetector.DetectGroup(text)
+	if !ok {
+		return false, nil
+	}
+	if err := w.Output.ClearRight(Pos{Line: w.Position.Line}); err != nil {
+		return true, err
+	}
+	w.Position.Col = 0
+	w.PendingWrap = false
+	w.groupMarker(marker)
+	return true, nil
+}
+
+func (w *Writer) groupOSC(cmd OperatingSystemCommand) {
+	if d, ok := w.GroupDetector.(OSCGroupDetector); ok && !w.AltScreen {
+		if marker, ok := d.DetectGroupOSC(cmd); ok {
+			w.groupMarker(marker)
+		}
+	}
+}
+
+func (w *Writer) groupMarker(marker GroupMarker) {
+	line := w.Position.Line
+	if marker.End {
+		if n := len(w.openGroups); n > 0 {
+			group := w.openGroups[n-1]
+			group.EndLine = line
+			group.Open = false
+			w.openGroups = w.openGroups[:n-1]
+		}
+		return
+	}
+
+	group := &Group{Title: marker.Title, StartLine: line, Open: true}
+	if n := len(w.openGroups); n > 0 {
+		parent := w.openGroups[n-1]
+		parent.Groups = append(parent.Groups, group)
+	} else {
+		w.Groups = append(w.Groups, group)
+	}
+	w.openGroups = append(w.openGroups, group)
+}
+
+// WithGroupDetector strips the group markers recognised by d from the
+// output, and keeps track of the groups in Writer.Groups.
+func WithGroupDetector(d GroupDetector) WriterOption {
+	return func(w *Writer) {
+		w.GroupDetector = d
+	}
+}
@@ -0,0 +1,314 @@
+package ansi
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"strconv"
+)
+
+// ImageProtocol is how an inline image was sent.
+type ImageProtocol string
+
+const (
+	// ITermImages is iTerm2's "\x1b]1337;File=...:<base64>\x07".
+	ITermImages ImageProtocol = "iterm2"
+	// KittyImages is kitty's graphics protocol, "\x1b_G...;<base64>\x1b\\".
+	KittyImages ImageProtocol = "kitty"
+	// SixelImages is DEC's sixel graphics, "\x1bPq...\x1b\\".
+	SixelImages ImageProtocol = "sixel"
+)
+
+// Caps how large an image may get once decompressed
+const maxImageSize = 1 << 26
+
+// The character Lines put in place of an image, U+FFFC OBJECT REPLACEMENT
+// CHARACTER
+var imagePlaceholder = []byte("\uFFFC")
+
+// Image is an image shown inline in the output.
+type Image struct {
+	Protocol ImageProtocol `json:"protocol"`
+	// Format is the MIME type of Data when it's an image file, if it could
+	// be told, "rgb" or "rgba" for raw pixels, or "sixel".
+	Format string `json:"format,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Data   []byte `json:"data"`
+
+	// Width and Height are the size in cells that was asked for, and
+	// PixelWidth and PixelHeight the size in pixels, when known.
+	Width       int `json:"width,omitempty"`
+	Height      int `json:"height,omitempty"`
+	PixelWidth  int `json:"pixelWidth,omitempty"`
+	PixelHeight int `json:"pixelHeight,omitempty"`
+}
+
+// ImageOutput is implemented by Outputs that keep inline images, which are
+// dropped otherwise.
+type ImageOutput interface {
+	PrintImage(image *Image, style Style, pos Pos) error
+}
+
+// PrintImage puts a placeholder chunk holding image at pos, taking up a
+// single cell.
+func (l *Lines) PrintImage(image *Image, style Style, pos Pos) error {
+	return l.PrintChunk(Chunk{Style: style, Image: image}, pos)
+}
+
+// printImage puts chunk, holding an image, at pos as its placeholder.
+func (l *Lines) printImage(image Chunk, pos Pos) error {
+	text := image
+	text.Data = imagePlaceholder
+	text.Image = nil
+	if err := l.PrintChunk(text, pos); err != nil {
+		return err
+	}
+	if pos.Line < 0 {
+		pos.Line = 0
+	}
+	if pos.Col < 0 {
+		pos.Col = 0
+	}
+
+	// Split the placeholder off from whatever it may have been merged with
+	wrapped := l.isWrapped(pos.Line)
+	line := (*l)[pos.Line]
+	chunkEnd := 0
+	for i, chunk := range line {
+		chunkStart := chunkEnd
+		chunkEnd += textWidth(chunk.Data)
+		if chunkEnd <= pos.Col {
+			continue
+		}
+		head := chunkHead(chunk, pos.Col-chunkStart)
+		head.Data = head.Data[:len(head.Data):len(head.Data)]
+		tail := chunkTail(chunk, pos.Col-chunkStart+1)
+		placeholder := image
+		placeholder.Data = copyBytes(imagePlaceholder)
+
+		newLine := append(make(Line, 0, len(line)+2), line[:i]...)
+		if len(head.Data) > 0 {
+			newLine = append(newLine, head)
+		}
+		newLine = append(newLine, placeholder)
+		if len(tail.Data) > 0 {
+			newLine = append(newLine, tail)
+		}
+		(*l)[pos.Line] = append(newLine, line[i+1:]...)
+		break
+	}
+	l.setWrapped(pos.Line, wrapped)
+	return nil
+}
+
+func (w *Writer) printImage(image Image) error {
+	_, chunked := w.Output.(ChunkOutput)
+	if _, ok := w.Output.(ImageOutput); !ok && !chunked {
+		return nil
+	}
+	if w.FixedWidth && w.PendingWrap {
+		if err := w.wrapLine(); err != nil {
+			return err
+		}
+	}
+	if err := w.outputChunk(w.tagged(Chunk{Style: w.Style, Image: &image}), w.Position); err != nil {
+		return err
+	}
+	w.touch(w.Position.Line)
+
+	// Move past the placeholder like any other character
+	switch {
+	case !w.FixedWidth:
+		w.Position.Col++
+		if w.Position.Col > w.MaxCol {
+			w.MaxCol = w.Position.Col
+		}
+	case w.Position.Col+1 >= w.MaxCol:
+		w.Position.Col = w.MaxCol - 1
+		w.PendingWrap = w.Autowrap
+	default:
+		w.Position.Col++
+	}
+	return nil
+}
+
+// parseITermImage reads the arguments of an OSC 1337, e.g.
+// "File=name=<base64>;width=10;inline=1:<base64>". Files that aren't to be
+// shown inline are downloads rather than images, and are left out.
+func parseITermImage(args []byte) (Image, bool) {
+	const prefix = "File="
+	if !bytes.HasPrefix(args, []byte(prefix)) {
+		return Image{}, false
+	}
+	args = args[len(prefix):]
+	colon := bytes.IndexByte(args, ':')
+	if colon < 0 {
+		return Image{}, false
+	}
+	params, payload := args[:colon], args[colon+1:]
+
+	image := Image{Protocol: ITermImages}
+	inline := false
+	for _, param := range bytes.Split(params, []byte{';'}) {
+		eq := bytes.IndexByte(param, '=')
+		if eq < 0 {
+			continue
+		}
+		key, value := string(param[:eq]), param[eq+1:]
+		switch key {
+		case "name":
+			if name, err := base64.StdEncoding.DecodeString(string(value)); err == nil {
+				image.Name = string(name)
+			}
+		case "width":
+			image.Width, image.PixelWidth = iTermSize(value)
+		case "height":
+			image.Height, image.PixelHeight = iTermSize(value)
+		case "inline":
+			inline = string(value) == "1"
+		}
+	}
+	if !inline {
+		return Image{}, false
+	}
+
+	data, err := base64.StdEncoding.DecodeString(string(payload))
+	if err != nil {
+		return Image{}, false
+	}
+	image.Data = data
+	image.Format = sniffImageFormat(data)
+	return image, true
+}
+
+// iTermSize reads a width or height, which is in cells unless it ends with
+// "px". Percentages and "auto" aren't known sizes.
+func iTermSize(value []byte) (cells, pixels int) {
+	if n, ok := atoi(bytes.TrimSuffix(value, []byte("px"))); ok {
+		if bytes.HasSuffix(value, []byte("px")) {
+			return 0, n
+		}
+		return n, 0
+	}
+	return 0, 0
+}
+
+// kittyImage is an image sent in chunks with kitty's "m=1".
+type kittyImage struct {
+	params  map[string]string
+	payload []byte
+}
+
+// kittyGraphics reads a kitty graphics command, e.g. "a=T,f=100;<base64>".
+// Only transmissions that are displayed right away ("a=T") of data sent
+// directly rather than through files are images here.
+func (p *Parser) kittyGraphics(command []byte) (Image, bool) {
+	if !p.Compatibility.extensions() {
+		return Image{}, false
+	}
+	control, payload := command, []byte(nil)
+	if i := bytes.IndexByte(command, ';'); i >= 0 {
+		control, payload = command[:i], command[i+1:]
+	}
+	params := make(map[string]string)
+	for _, param := range bytes.Split(control, []byte{','}) {
+		if eq := bytes.IndexByte(param, '='); eq >= 0 {
+			params[string(param[:eq])] = string(param[eq+1:])
+		}
+	}
+
+	more := params["m"] == "1"
+	if pending := p.kittyImage; pending != nil {
+		// Later chunks only carry "m", the rest came with the first one
+		pending.payload = append(pending.payload, payload...)
+		if more {
+			if len(pending.payload) > maxStringLength {
+				p.kittyImage = nil
+			}
+			return Image{}, false
+		}
+		p.kittyImage = nil
+		params, payload = pending.params, pending.payload
+	} else if more {
+		p.kittyImage = &kittyImage{params: params, payload: copyBytes(payload)}
+		return Image{}, false
+	}
+
+	if params["a"] != "T" || (params["t"] != "" && params["t"] != "d") {
+		return Image{}, false
+	}
+	data, err := base64.StdEncoding.DecodeString(string(payload))
+	if err != nil {
+		return Image{}, false
+	}
+	if params["o"] == "z" {
+		r, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return Image{}, false
+		}
+		// Read one byte more than allowed to tell an image that's too large
+		// from one that's just the right size
+		data, err = ioutil.ReadAll(io.LimitReader(r, maxImageSize+1))
+		if err != nil || len(data) > maxImageSize {
+			return Image{}, false
+		}
+	}
+
+	image := Image{Protocol: KittyImages, Data: data}
+	switch params["f"] {
+	case "100":
+		image.Format = "image/png"
+	case "24":
+		image.Format = "rgb"
+	case "", "32":
+		image.Format = "rgba"
+	}
+	image.Width, _ = strconv.Atoi(params["c"])
+	image.Height, _ = strconv.Atoi(params["r"])
+	image.PixelWidth, _ = strconv.Atoi(params["s"])
+	image.PixelHeight, _ = strconv.Atoi(params["v"])
+	return image, true
+}
+
+// parseSixelImage reads the contents of a sixel DCS, e.g.
+// "0;0;0q\"1;1;64;32#0;2;0;0;0...". The size in pixels comes from the
+// raster attributes, if there are any.
+func parseSixelImage(dcs []byte) (Image, bool) {
+	i := 0
+	for i < len(dcs) && (isDigit(dcs[i]) || dcs[i] == ';') {
+		i++
+	}
+	if i >= len(dcs) || dcs[i] != 'q' {
+		return Image{}, false
+	}
+	data := dcs[i+1:]
+
+	image := Image{Protocol: SixelImages, Format: "sixel", Data: copyBytes(data)}
+	if len(data) > 0 && data[0] == '"' {
+		end := 1
+		for end < len(data) && (isDigit(data[end]) || data[end] == ';') {
+			end++
+		}
+		attrs := bytes.Split(data[1:end], []byte{';'})
+		if len(attrs) == 4 {
+			image.PixelWidth, _ = atoi(attrs[2])
+			image.PixelHeight, _ = atoi(attrs[3])
+		}
+	}
+	return image, true
+}
+
+// sniffImageFormat tells the MIME type of the most common image files.
+func sniffImageFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return "image/jpeg"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "image/gif"
+	}
+	return ""
+}
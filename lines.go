@@ -3,6 +3,7 @@ package ansi
 import (
 	"bytes"
 	"encoding/json"
+	"time"
 )
 
 // Used as an optimization to avoid heap allocations
@@ -34,6 +35,18 @@ func (t *Text) UnmarshalJSON(data []byte) error {
 type Chunk struct {
 	Data  Text  `json:"data"`
 	Style Style `json:"style"`
+	// Wrapped is set on the last chunk of a line that was soft-wrapped onto
+	// the next one by autowrap.
+	Wrapped bool `json:"wrapped,omitempty"`
+	// Image is set on the placeholder chunk of an inline image.
+	Image *Image `json:"image,omitempty"`
+	// Stream is the name of the input stream the text was written to, see
+	// Writer.Stream. It's empty for text written to the Writer itself.
+	Stream string `json:"stream,omitempty"`
+	// Time is when the text was printed, with WithTimestamps. It's in UTC
+	// and without a monotonic clock reading, and shared between chunks
+	// printed at the same time, so it mustn't be modified.
+	Time *time.Time `json:"time,omitempty"`
 }
 
 type Line = []Chunk
@@ -41,12 +54,35 @@ type Line = []Chunk
 type Lines []Line
 
 func (l *Lines) Print(data []byte, style Style, pos Pos) error {
+	return l.PrintChunk(Chunk{Data: data, Style: style}, pos)
+}
+
+// PrintChunk prints chunk's data at pos like Print, tagging it with the rest
+// of chunk's fields. It implements ChunkOutput.
+func (l *Lines) PrintChunk(chunk Chunk, pos Pos) error {
+	chunk.Wrapped = false
+	if chunk.Image != nil {
+		return l.printImage(chunk, pos)
+	}
 	if pos.Line < 0 {
 		pos.Line = 0
 	}
 	if pos.Col < 0 {
 		pos.Col = 0
 	}
+	if l.isWrapped(pos.Line) {
+		// Chunks may be split or replaced, so move the mark back onto
+		// whichever chunk ends up last
+		l.print(chunk, pos)
+		l.setWrapped(pos.Line, true)
+		return nil
+	}
+	l.print(chunk, pos)
+	return nil
+}
+
+// print prints the data of chunk, which must not be retained, at pos.
+func (l *Lines) print(chunk Chunk, pos Pos) {
 	numEmpty := pos.Line - len(*l)
 	for numEmpty > 0 {
 		*l = append(*l, Line{})
@@ -54,141 +90,208 @@ func (l *Lines) Print(data []byte, style Style, pos Pos) error {
 	}
 	if pos.Line >= len(*l) {
 		spacerLen := pos.Col
-		newData := make([]byte, spacerLen+len(data))
+		newData := make([]byte, spacerLen+len(chunk.Data))
 		copy(newData, spacer(spacerLen))
-		copy(newData[spacerLen:], data)
-		*l = append(*l, Line{{Data: newData, Style: style}})
-		return nil
+		copy(newData[spacerLen:], chunk.Data)
+		chunk.Data = newData
+		*l = append(*l, Line{chunk})
+		return
 	}
 
-	lineLen := l.lineLength(pos.Line)
-
-	if pos.Col >= lineLen {
-		l.appendToLine(data, style, pos)
-	} else {
-		l.insertWithinLine(data, style, pos)
+	if inserted, lineLen := l.insertWithinLine(chunk, pos); !inserted {
+		l.appendToLine(chunk, pos, lineLen)
 	}
-	return nil
 }
 
-func (l Lines) appendToLine(data []byte, style Style, pos Pos) {
+func (l Lines) appendToLine(chunk Chunk, pos Pos, lineLen int) {
 	line := l[pos.Line]
 
-	lineLen := l.lineLength(pos.Line)
 	spacerLen := pos.Col - lineLen
 
 	if len(line) == 0 {
-		l.addFirstChunk(data, style, pos)
+		l.addFirstChunk(chunk, pos)
 		return
 	}
 
 	lastChunk := &line[len(line)-1]
 	lastChunk.Data = append(lastChunk.Data, spacer(spacerLen)...)
-	if lastChunk.Style == style {
-		lastChunk.Data = append(lastChunk.Data, data...)
+	if canMerge(*lastChunk, chunk) {
+		lastChunk.Data = append(lastChunk.Data, chunk.Data...)
 		return
 	}
-	newData := make([]byte, len(data))
-	copy(newData, data)
-	l[pos.Line] = append(line, Chunk{Data: newData, Style: style})
+	chunk.Data = copyBytes(chunk.Data)
+	l[pos.Line] = append(line, chunk)
 }
 
-func (l Lines) addFirstChunk(data []byte, style Style, pos Pos) {
-	newData := make([]byte, pos.Col+len(data))
+func (l Lines) addFirstChunk(chunk Chunk, pos Pos) {
+	newData := make([]byte, pos.Col+len(chunk.Data))
 	copy(newData, spacer(pos.Col))
-	copy(newData[pos.Col:], data)
-	l[pos.Line] = Line{{Data: newData, Style: style}}
+	copy(newData[pos.Col:], chunk.Data)
+	chunk.Data = newData
+	l[pos.Line] = Line{chunk}
 }
 
-func (l Lines) insertWithinLine(data []byte, style Style, pos Pos) {
+// insertWithinLine prints over the part of the line that printed covers, if
+// the line reaches pos, or returns how long the line is otherwise. Only the
+// chunks up to the end of printed are measured, and the line is changed in
+// place when it can be.
+func (l Lines) insertWithinLine(printed Chunk, pos Pos) (inserted bool, lineLen int) {
 	line := l[pos.Line]
-	chunkStart := 0
-	for i := 0; i < len(line); i++ {
-		chunk := line[i]
-		chunkInterval := intervalWithWidth(chunkStart, len(chunk.Data))
-		printInterval := intervalWithWidth(pos.Col, len(data))
+	width := textWidth(printed.Data)
+	from, to := pos.Col, pos.Col+width
 
-		relCol := pos.Col - chunkStart
-		chunkStart += len(chunk.Data)
+	// The chunks from start up to end are replaced, including the one
+	// before printed, which it may be merged into
+	var replaced [4]Chunk
+	newChunks := replaced[:0]
+	start, end := -1, len(line)
+	chunkEnd := 0
+	for i, chunk := range line {
+		chunkStart := chunkEnd
+		chunkEnd += textWidth(chunk.Data)
 
-		if !chunkInterval.overlaps(printInterval) {
+		if chunkEnd <= from {
 			continue
 		}
+		if start < 0 {
+			if chunkStart <= from && to <= chunkEnd && canMerge(chunk, printed) &&
+				overwriteInsideChunk(chunk, printed.Data, from-chunkStart, width) {
+				return true, 0
+			}
+			start = i
+			if i > 0 {
+				start--
+				newChunks = append(newChunks, line[start])
+			}
+		}
 
-		if chunkInterval.contains(printInterval) {
-			l.insertInsideChunk(data, style, pos.Line, relCol, i)
-			return
+		if chunkStart < from {
+			head := chunkHead(chunk, from-chunkStart)
+			if chunkEnd > to {
+				// The tail is still in use, appending to the head must not
+				// overwrite it
+				head.Data = head.Data[:len(head.Data):len(head.Data)]
+			}
+			newChunks = appendChunk(newChunks, head)
 		}
-		newLine := append(make(Line, 0, len(line)+1), line[:i]...)
-		originalChunkLength := len(chunk.Data)
-
-		chunk.Data = chunk.Data[:relCol]
-		if chunk.Style == style {
-			chunk.Data = append(chunk.Data, data...)
-			newLine = append(newLine, chunk)
-		} else {
-			if len(chunk.Data) > 0 {
-				newLine = append(newLine, chunk)
+		if !inserted {
+			if n := len(newChunks); n > 0 && canMerge(newChunks[n-1], printed) {
+				newChunks[n-1].Data = append(newChunks[n-1].Data, printed.Data...)
+			} else {
+				newChunk := printed
+				newChunk.Data = copyBytes(printed.Data)
+				newChunks = append(newChunks, newChunk)
 			}
-			newData := make([]byte, len(data))
-			copy(newData, data)
-			newLine = append(newLine, Chunk{Data: newData, Style: style})
+			inserted = true
+		}
+		if chunkStart >= to {
+			newChunks = appendChunk(newChunks, chunk)
+			end = i + 1
+			break
+		}
+		if chunkEnd > to {
+			newChunks = appendChunk(newChunks, chunkTail(chunk, to-chunkStart))
+			end = i + 1
+			break
 		}
+	}
+	if !inserted {
+		return false, chunkEnd
+	}
+	l[pos.Line] = spliceChunks(line, start, end, newChunks)
+	return true, 0
+}
 
-		bytesToRemove := len(data) - originalChunkLength + relCol
-		l.removeBytesInLine(bytesToRemove, i, pos.Line, &newLine)
-		l[pos.Line] = newLine
-		return
+// spliceChunks replaces the chunks of line from start up to end with chunks,
+// reusing line's array if it's big enough.
+func spliceChunks(line Line, start, end int, chunks []Chunk) Line {
+	oldLen := len(line)
+	newLen := oldLen - (end - start) + len(chunks)
+	if newLen > cap(line) {
+		newLine := make(Line, newLen, newLen+2)
+		copy(newLine, line[:start])
+		copy(newLine[start:], chunks)
+		copy(newLine[start+len(chunks):], line[end:])
+		return newLine
 	}
+	line = line[:newLen]
+	copy(line[start+len(chunks):], line[end:oldLen])
+	copy(line[start:], chunks)
+	for i := newLen; i < oldLen; i++ {
+		// Don't keep dropped chunks alive through the array
+		line[:oldLen][i] = Chunk{}
+	}
+	return line
 }
 
-func (l Lines) insertInsideChunk(data []byte, style Style, lineNum, relCol int, chunkIndex int) {
-	chunk := l[lineNum][chunkIndex]
-	if chunk.Style == style {
-		copy(l[lineNum][chunkIndex].Data[relCol:], data)
-		return
+// overwriteInsideChunk handles the common case of overwriting text of the
+// same style in place, when the replaced text is as many bytes long as data.
+func overwriteInsideChunk(chunk Chunk, data []byte, relCol, width int) bool {
+	if width == 0 {
+		return false
 	}
-	line := l[lineNum]
-	newLine := make(Line, 0, len(line)+2)
-	newLine = append(newLine, line[:chunkIndex]...)
-	if relCol > 0 {
-		leftChunk := chunk
-		leftChunk.Data = leftChunk.Data[:relCol]
-		newLine = append(newLine, leftChunk)
-	}
-	newLine = append(newLine, Chunk{Data: data, Style: style})
-	if relCol+len(data) < len(chunk.Data) {
-		rightChunk := chunk
-		rightChunk.Data = rightChunk.Data[relCol+len(data):]
-		newLine = append(newLine, rightChunk)
-	}
-	newLine = append(newLine, line[chunkIndex+1:]...)
-	l[lineNum] = newLine
-	return
-}
-
-func (l Lines) removeBytesInLine(bytesToRemove int, chunkIndex int, lineNum int, newLine *Line) {
-	line := l[lineNum]
-	for i := chunkIndex + 1; i < len(line); i++ {
-		chunk := line[i]
-		if bytesToRemove >= len(chunk.Data) {
-			bytesToRemove -= len(chunk.Data)
-			continue
-		}
-		if bytesToRemove > 0 {
-			line[i].Data = chunk.Data[bytesToRemove:]
-		}
-		*newLine = append(*newLine, line[i:]...)
-		return
+	start, _, padStart, _ := splitCells(chunk.Data, relCol)
+	end, _, padEnd, _ := splitCells(chunk.Data, relCol+width)
+	if padStart != 0 || padEnd != 0 || end-start != len(data) {
+		return false
+	}
+	copy(chunk.Data[start:end], data)
+	return true
+}
+
+// chunkHead is the part of chunk covering the cells before col.
+func chunkHead(chunk Chunk, col int) Chunk {
+	before, _, pad, _ := splitCells(chunk.Data, col)
+	if pad > 0 {
+		newData := make([]byte, before+pad)
+		copy(newData, chunk.Data[:before])
+		copy(newData[before:], spacer(pad))
+		chunk.Data = newData
+		return chunk
 	}
+	chunk.Data = chunk.Data[:before]
+	return chunk
+}
+
+// chunkTail is the part of chunk covering the cells from col onwards.
+func chunkTail(chunk Chunk, col int) Chunk {
+	_, after, _, pad := splitCells(chunk.Data, col)
+	if pad > 0 {
+		newData := make([]byte, pad+len(chunk.Data)-after)
+		copy(newData, spacer(pad))
+		copy(newData[pad:], chunk.Data[after:])
+		chunk.Data = newData
+		return chunk
+	}
+	chunk.Data = chunk.Data[after:]
+	return chunk
+}
+
+// appendChunk appends chunk to line, merging it into the last chunk if
+// canMerge allows it.
+func appendChunk(line Line, chunk Chunk) Line {
+	if len(chunk.Data) == 0 {
+		return line
+	}
+	if n := len(line); n > 0 && canMerge(line[n-1], chunk) {
+		line[n-1].Data = append(line[n-1].Data, chunk.Data...)
+		return line
+	}
+	return append(line, chunk)
+}
+
+// canMerge is whether b can be merged into a: they need to share a style, a
+// stream and a time, and neither can be an image.
+func canMerge(a, b Chunk) bool {
+	return a.Style == b.Style && a.Stream == b.Stream && sameTime(a.Time, b.Time) &&
+		a.Image == nil && b.Image == nil
 }
 
-func (l Lines) lineLength(i int) int {
-	length := 0
-	for _, chunk := range l[i] {
-		length += len(chunk.Data)
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
 	}
-	return length
+	return a.Equal(*b)
 }
 
 func (l Lines) ClearRight(pos Pos) error {
@@ -198,16 +301,17 @@ func (l Lines) ClearRight(pos Pos) error {
 	if pos.Col < 0 {
 		pos.Col = 0
 	}
+	l.setWrapped(pos.Line, false)
 	line := l[pos.Line]
 	chunkEnd := 0
 	for i := 0; i < len(line); i++ {
 		chunk := &line[i]
 		chunkStart := chunkEnd
-		chunkEnd += len(chunk.Data)
+		chunkEnd += textWidth(chunk.Data)
 		if chunkEnd < pos.Col {
 			continue
 		}
-		chunk.Data = chunk.Data[:pos.Col-chunkStart]
+		*chunk = chunkHead(*chunk, pos.Col-chunkStart)
 		keepUpToChunk := i
 		if len(chunk.Data) == 0 {
 			keepUpToChunk--
@@ -218,6 +322,35 @@ func (l Lines) ClearRight(pos Pos) error {
 	return nil
 }
 
+func (l Lines) MarkWrapped(line int) error {
+	l.setWrapped(line, true)
+	return nil
+}
+
+func (l Lines) isWrapped(i int) bool {
+	if i < 0 || i >= len(l) || len(l[i]) == 0 {
+		return false
+	}
+	return l[i][len(l[i])-1].Wrapped
+}
+
+func (l Lines) setWrapped(i int, wrapped bool) {
+	if i < 0 || i >= len(l) || len(l[i]) == 0 {
+		return
+	}
+	line := l[i]
+	for j := range line {
+		line[j].Wrapped = false
+	}
+	line[len(line)-1].Wrapped = wrapped
+}
+
+func copyBytes(data []byte) []byte {
+	newData := make([]byte, len(data))
+	copy(newData, data)
+	return newData
+}
+
 func spacer(length int) []byte {
 	if length <= 0 {
 		return nil
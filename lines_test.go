@@ -1,8 +1,13 @@
 package ansi_test
 
 import (
+	"bytes"
 	"encoding/json"
+	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/cowdude/ansi"
 	. "github.com/onsi/gomega"
@@ -437,6 +442,102 @@ func TestLines_Print(t *testing.T) {
 				},
 			},
 		},
+		{
+			description: "columns count wide characters as two cells",
+			printCalls: []printCall{
+				{
+					data: []byte("日本語"),
+					pos:  ansi.Pos{Line: 0, Col: 0},
+				},
+				{
+					data: []byte("!"),
+					pos:  ansi.Pos{Line: 0, Col: 8},
+				},
+			},
+			lines: ansi.Lines{
+				{
+					{
+						Data: ansi.Text("日本語  !"),
+					},
+				},
+			},
+		},
+		{
+			description: "overwriting half of a wide character replaces the other half with a space",
+			printCalls: []printCall{
+				{
+					data: []byte("日本語"),
+					pos:  ansi.Pos{Line: 0, Col: 0},
+				},
+				{
+					data:  []byte("ab"),
+					pos:   ansi.Pos{Line: 0, Col: 1},
+					style: ansi.Style{Modifier: ansi.Bold},
+				},
+			},
+			lines: ansi.Lines{
+				{
+					{
+						Data: ansi.Text(" "),
+					},
+					{
+						Data:  ansi.Text("ab"),
+						Style: ansi.Style{Modifier: ansi.Bold},
+					},
+					{
+						Data: ansi.Text(" 語"),
+					},
+				},
+			},
+		},
+		{
+			description: "combining marks take no space and are overwritten with their base",
+			printCalls: []printCall{
+				{
+					data: []byte("cafe\u0301 ole\u0301"),
+					pos:  ansi.Pos{Line: 0, Col: 0},
+				},
+				{
+					data: []byte("E"),
+					pos:  ansi.Pos{Line: 0, Col: 3},
+				},
+				{
+					data: []byte("\u0300"),
+					pos:  ansi.Pos{Line: 0, Col: 8},
+				},
+			},
+			lines: ansi.Lines{
+				{
+					{
+						Data: ansi.Text("cafE ole\u0301\u0300"),
+					},
+				},
+			},
+		},
+		{
+			description: "emoji sequences are kept intact",
+			printCalls: []printCall{
+				{
+					data: []byte("a👩\u200d💻b🇫🇷c"),
+					pos:  ansi.Pos{Line: 0, Col: 0},
+				},
+				{
+					data: []byte("B"),
+					pos:  ansi.Pos{Line: 0, Col: 3},
+				},
+				{
+					data: []byte("C"),
+					pos:  ansi.Pos{Line: 0, Col: 6},
+				},
+			},
+			lines: ansi.Lines{
+				{
+					{
+						Data: ansi.Text("a👩\u200d💻B🇫🇷C"),
+					},
+				},
+			},
+		},
 	} {
 		t.Run(tt.description, func(t *testing.T) {
 			g := NewGomegaWithT(t)
@@ -614,6 +715,28 @@ func TestLines_ClearRight(t *testing.T) {
 				{},
 			},
 		},
+		{
+			description: "clearing from the middle of a wide character leaves a space",
+			initLines: ansi.Lines{
+				{
+					{
+						Data: ansi.Text("日本語"),
+					},
+				},
+			},
+			clearCalls: []clearCall{
+				{
+					pos: ansi.Pos{Line: 0, Col: 3},
+				},
+			},
+			lines: ansi.Lines{
+				{
+					{
+						Data: ansi.Text("日 "),
+					},
+				},
+			},
+		},
 	} {
 		t.Run(tt.description, func(t *testing.T) {
 			g := NewGomegaWithT(t)
@@ -646,3 +769,459 @@ func TestText_UnmarshalJSON(t *testing.T) {
 	g.Expect(err).ToNot(HaveOccurred())
 	g.Expect(text).To(Equal(ansi.Text("hello world\x1b")))
 }
+
+func TestLines_PrintChunk(t *testing.T) {
+	g := NewGomegaWithT(t)
+	var lines ansi.Lines
+
+	g.Expect(lines.PrintChunk(ansi.Chunk{Data: ansi.Text("ab"), Stream: "out"}, ansi.Pos{})).To(Succeed())
+	g.Expect(lines.PrintChunk(ansi.Chunk{Data: ansi.Text("c"), Stream: "err"}, ansi.Pos{Col: 2})).To(Succeed())
+	g.Expect(lines.PrintChunk(ansi.Chunk{Data: ansi.Text("x"), Stream: "err"}, ansi.Pos{})).To(Succeed())
+	g.Expect(lines.PrintChunk(ansi.Chunk{Data: ansi.Text("y"), Stream: "out"}, ansi.Pos{Col: 1})).To(Succeed())
+	g.Expect(lines).To(Equal(ansi.Lines{{
+		{Data: ansi.Text("x"), Stream: "err"},
+		{Data: ansi.Text("y"), Stream: "out"},
+		{Data: ansi.Text("c"), Stream: "err"},
+	}}))
+
+	// Chunks from different streams aren't merged when rewrapped either
+	_, err := lines.Reflow(1, ansi.Pos{})
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = lines.Reflow(3, ansi.Pos{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(lines).To(Equal(ansi.Lines{{
+		{Data: ansi.Text("x"), Stream: "err"},
+		{Data: ansi.Text("y"), Stream: "out"},
+		{Data: ansi.Text("c"), Stream: "err"},
+	}}))
+}
+
+func TestLines_Reflow(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		initLines   ansi.Lines
+		cols        int
+		cursor      ansi.Pos
+		lines       ansi.Lines
+		newCursor   ansi.Pos
+	}{
+		{
+			description: "rejoins wrapped lines when getting wider",
+			initLines: ansi.Lines{
+				{
+					{Data: ansi.Text("abc"), Wrapped: true},
+				},
+				{
+					{Data: ansi.Text("de")},
+					{Data: ansi.Text("f"), Style: ansi.Style{Modifier: ansi.Bold}},
+				},
+				{
+					{Data: ansi.Text("ghi")},
+				},
+			},
+			cols:   10,
+			cursor: ansi.Pos{Line: 1, Col: 1},
+			lines: ansi.Lines{
+				{
+					{Data: ansi.Text("abcde")},
+					{Data: ansi.Text("f"), Style: ansi.Style{Modifier: ansi.Bold}},
+				},
+				{
+					{Data: ansi.Text("ghi")},
+				},
+			},
+			newCursor: ansi.Pos{Line: 0, Col: 4},
+		},
+		{
+			description: "wraps long lines when getting narrower",
+			initLines: ansi.Lines{
+				{
+					{Data: ansi.Text("abcde")},
+					{Data: ansi.Text("f"), Style: ansi.Style{Modifier: ansi.Bold}},
+				},
+				{},
+				{
+					{Data: ansi.Text("ghi")},
+				},
+			},
+			cols:   2,
+			cursor: ansi.Pos{Line: 2, Col: 3},
+			lines: ansi.Lines{
+				{
+					{Data: ansi.Text("ab"), Wrapped: true},
+				},
+				{
+					{Data: ansi.Text("cd"), Wrapped: true},
+				},
+				{
+					{Data: ansi.Text("e")},
+					{Data: ansi.Text("f"), Style: ansi.Style{Modifier: ansi.Bold}},
+				},
+				{},
+				{
+					{Data: ansi.Text("gh"), Wrapped: true},
+				},
+				{
+					{Data: ansi.Text("i")},
+				},
+			},
+			newCursor: ansi.Pos{Line: 5, Col: 1},
+		},
+		{
+			description: "wide characters that don't fit move to the next line",
+			initLines: ansi.Lines{
+				{
+					{Data: ansi.Text("a日本")},
+				},
+			},
+			cols:   4,
+			cursor: ansi.Pos{Line: 0, Col: 5},
+			lines: ansi.Lines{
+				{
+					{Data: ansi.Text("a日"), Wrapped: true},
+				},
+				{
+					{Data: ansi.Text("本")},
+				},
+			},
+			newCursor: ansi.Pos{Line: 1, Col: 2},
+		},
+		{
+			description: "a cursor below the text keeps its distance",
+			initLines: ansi.Lines{
+				{
+					{Data: ansi.Text("abcd")},
+				},
+			},
+			cols:      2,
+			cursor:    ansi.Pos{Line: 2, Col: 0},
+			lines:     ansi.Lines{{{Data: ansi.Text("ab"), Wrapped: true}}, {{Data: ansi.Text("cd")}}},
+			newCursor: ansi.Pos{Line: 3, Col: 0},
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			out := tt.initLines
+
+			newPositions, err := out.Reflow(tt.cols, tt.cursor)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			g.Expect(out).To(Equal(tt.lines))
+			g.Expect(newPositions).To(Equal([]ansi.Pos{tt.newCursor}))
+		})
+	}
+}
+
+func TestGroupedLines_MarshalJSON(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	grouped := ansi.GroupedLines{
+		Lines: ansi.Lines{{{Data: ansi.Text("a")}}},
+		Groups: []*ansi.Group{
+			{Title: "Build", StartLine: 0, EndLine: 1, Groups: []*ansi.Group{
+				{Title: "Step", StartLine: 0, Open: true},
+			}},
+		},
+	}
+	marshalled, err := json.Marshal(grouped)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(marshalled).To(MatchJSON(`{
+		"lines": [[{"data": "a", "style": {}}]],
+		"groups": [{
+			"title": "Build", "start": 0, "end": 1,
+			"groups": [{"title": "Step", "start": 0, "end": 0, "open": true}]
+		}]
+	}`))
+}
+
+func TestLineScanner(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		window      int
+		input       string
+		lines       ansi.Lines
+		lineNumbers []int
+	}{
+		{
+			description: "yields every line",
+			input:       "\x1b[1mone\x1b[m\ntwo\n\nfour\n",
+			lines: ansi.Lines{
+				{{Data: ansi.Text("one"), Style: ansi.Style{Modifier: ansi.Bold}}},
+				{{Data: ansi.Text("two")}},
+				{},
+				{{Data: ansi.Text("four")}},
+			},
+			lineNumbers: []int{0, 1, 2, 3},
+		},
+		{
+			description: "yields the last line even without a linebreak",
+			input:       "one\ntwo",
+			lines: ansi.Lines{
+				{{Data: ansi.Text("one")}},
+				{{Data: ansi.Text("two")}},
+			},
+			lineNumbers: []int{0, 1},
+		},
+		{
+			description: "lines in the window can still be rewritten",
+			window:      2,
+			input:       "one\ntwo\nthree\n\x1b[2Aupdated\x1b[2B",
+			lines: ansi.Lines{
+				{{Data: ansi.Text("one")}},
+				{{Data: ansi.Text("updated")}},
+				{{Data: ansi.Text("three")}},
+			},
+			lineNumbers: []int{0, 1, 2},
+		},
+		{
+			description: "lines above the window can't be rewritten",
+			window:      1,
+			input:       "one\ntwo\nthree\n\x1b[3Aupdated",
+			lines: ansi.Lines{
+				{{Data: ansi.Text("one")}},
+				{{Data: ansi.Text("two")}},
+				{{Data: ansi.Text("three")}},
+			},
+			lineNumbers: []int{0, 1, 2},
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			s := ansi.NewLineScanner(iotest.OneByteReader(strings.NewReader(tt.input)))
+			s.Window = tt.window
+
+			var (
+				lines       ansi.Lines
+				lineNumbers []int
+			)
+			for s.Scan() {
+				lines = append(lines, s.Line())
+				lineNumbers = append(lineNumbers, s.LineNumber())
+			}
+
+			g.Expect(s.Err()).ToNot(HaveOccurred())
+			g.Expect(lines).To(Equal(tt.lines))
+			g.Expect(lineNumbers).To(Equal(tt.lineNumbers))
+		})
+	}
+}
+
+// stuckReader never reads anything, nor fails.
+type stuckReader struct{}
+
+func (stuckReader) Read([]byte) (int, error) {
+	return 0, nil
+}
+
+func TestLineScanner_NoProgress(t *testing.T) {
+	g := NewGomegaWithT(t)
+	s := ansi.NewLineScanner(stuckReader{})
+	g.Expect(s.Scan()).To(BeFalse())
+	g.Expect(s.Err()).To(Equal(io.ErrNoProgress))
+}
+
+func TestChangeLog(t *testing.T) {
+	g := NewGomegaWithT(t)
+	log := &ansi.ChangeLog{}
+	writer := ansi.NewWriter(log, ansi.WithFixedWidth(3), ansi.WithReflow())
+
+	var client ansi.Lines
+
+	_, err := writer.WriteString("one\ntwo")
+	g.Expect(err).ToNot(HaveOccurred())
+	changes := log.Changes(0)
+	g.Expect(changes.Version).To(Equal(log.Version()))
+	g.Expect(changes.Changes).To(Equal([]ansi.Change{
+		{Line: 0, Chunks: ansi.Line{{Data: ansi.Text("one")}}},
+		{Line: 1, Chunks: ansi.Line{{Data: ansi.Text("two")}}},
+	}))
+	client.Apply(changes.Changes)
+	g.Expect(client).To(Equal(log.Lines))
+
+	// Only lines that changed since are sent, as they are now
+	version := changes.Version
+	_, err = writer.WriteString("\rt\x1b[1mw\n\x1b[mfour")
+	g.Expect(err).ToNot(HaveOccurred())
+	changes = log.Changes(version)
+	g.Expect(changes.Changes).To(Equal([]ansi.Change{
+		{Line: 1, Chunks: ansi.Line{
+			{Data: ansi.Text("t")},
+			{Data: ansi.Text("w"), Style: ansi.Style{Modifier: ansi.Bold}},
+			{Data: ansi.Text("o")},
+		}},
+		{Line: 2, Chunks: ansi.Line{{Data: ansi.Text("fou"), Wrapped: true}}},
+		{Line: 3, Chunks: ansi.Line{{Data: ansi.Text("r")}}},
+	}))
+	client.Apply(changes.Changes)
+	g.Expect(client).To(Equal(log.Lines))
+
+	// Rewrapping to fewer lines drops the ones left over
+	version = changes.Version
+	g.Expect(writer.Resize(0, 4)).To(Succeed())
+	changes = log.Changes(version)
+	g.Expect(changes.Changes[0]).To(Equal(ansi.Change{Line: 3, Truncate: true}))
+	client.Apply(changes.Changes)
+	g.Expect(client).To(Equal(log.Lines))
+	g.Expect(client).To(HaveLen(3))
+
+	g.Expect(log.Changes(log.Version()).Changes).To(BeEmpty())
+
+	marshalled, err := json.Marshal(ansi.ChangeSet{Version: 7, Changes: []ansi.Change{
+		{Line: 2, Truncate: true},
+		{Line: 0, Chunks: ansi.Line{{Data: ansi.Text("a")}}},
+		{Line: 1},
+	}})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(marshalled).To(MatchJSON(`{
+		"version": 7,
+		"changes": [
+			{"line": 2, "truncate": true},
+			{"line": 0, "chunks": [{"data": "a", "style": {}}]},
+			{"line": 1}
+		]
+	}`))
+}
+
+func TestBoundedLines(t *testing.T) {
+	type spilled struct {
+		line int
+		text string
+	}
+	for _, tt := range []struct {
+		description string
+		maxLines    int
+		maxBytes    int
+		input       string
+		lines       ansi.Lines
+		offset      int
+		spilled     []spilled
+	}{
+		{
+			description: "keeps the last lines",
+			maxLines:    2,
+			input:       "one\ntwo\nthree\nfour",
+			lines: ansi.Lines{
+				{{Data: ansi.Text("three")}},
+				{{Data: ansi.Text("four")}},
+			},
+			offset:  2,
+			spilled: []spilled{{0, "one"}, {1, "two"}},
+		},
+		{
+			description: "keeps line numbers",
+			maxLines:    2,
+			input:       "one\ntwo\nthree\nfour\x1b[3A\rlost\x1b[2B\rT\n\n!",
+			lines: ansi.Lines{
+				{{Data: ansi.Text("four")}},
+				{{Data: ansi.Text("!")}},
+			},
+			offset:  3,
+			spilled: []spilled{{0, "one"}, {1, "two"}, {2, "Three"}},
+		},
+		{
+			description: "keeps the last bytes",
+			maxBytes:    6,
+			input:       "abc\ndef\ngh",
+			lines: ansi.Lines{
+				{{Data: ansi.Text("def")}},
+				{{Data: ansi.Text("gh")}},
+			},
+			offset:  1,
+			spilled: []spilled{{0, "abc"}},
+		},
+		{
+			description: "keeps the last line however long",
+			maxBytes:    2,
+			input:       "abc\ndefgh",
+			lines: ansi.Lines{
+				{{Data: ansi.Text("defgh")}},
+			},
+			offset:  1,
+			spilled: []spilled{{0, "abc"}},
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			var spills []spilled
+			lines := &ansi.BoundedLines{
+				MaxLines: tt.maxLines,
+				MaxBytes: tt.maxBytes,
+				Spill: func(line int, l ansi.Line) {
+					text := ""
+					for _, chunk := range l {
+						text += string(chunk.Data)
+					}
+					spills = append(spills, spilled{line, text})
+				},
+			}
+			writer := ansi.NewWriter(lines)
+			_, err := writer.WriteString(tt.input)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			g.Expect(lines.Lines).To(Equal(tt.lines))
+			g.Expect(lines.Offset).To(Equal(tt.offset))
+			g.Expect(lines.End()).To(Equal(tt.offset + len(tt.lines)))
+			g.Expect(spills).To(Equal(tt.spilled))
+		})
+	}
+}
+
+func TestFileLines(t *testing.T) {
+	g := NewGomegaWithT(t)
+	var data, index bytes.Buffer
+	lines := ansi.NewFileLines(&data, &index)
+	writer := ansi.NewWriter(lines, ansi.WithInitialScreenSize(2, 80))
+
+	_, err := writer.WriteString("\x1b[31mone\x1b[m\ntwo\nthree\nfour\n")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(lines.Committed()).To(Equal(2))
+	g.Expect(lines.Recent()).To(Equal(ansi.Lines{
+		{{Data: ansi.Text("three")}},
+		{{Data: ansi.Text("four")}},
+	}))
+
+	g.Expect(writer.Close()).To(Succeed())
+	g.Expect(lines.Committed()).To(Equal(4))
+	g.Expect(lines.Recent()).To(BeEmpty())
+	g.Expect(index.Len()).To(Equal(4 * 8))
+
+	all := ansi.Lines{
+		{{Data: ansi.Text("one"), Style: ansi.Style{Foreground: ansi.Red}}},
+		{{Data: ansi.Text("two")}},
+		{{Data: ansi.Text("three")}},
+		{{Data: ansi.Text("four")}},
+	}
+	for _, tt := range []struct {
+		from, to int
+		lines    ansi.Lines
+	}{
+		{0, 4, all},
+		{1, 3, all[1:3]},
+		{3, 10, all[3:]},
+		{5, 10, ansi.Lines{}},
+		{2, 2, ansi.Lines{}},
+	} {
+		read, err := ansi.ReadFileLines(bytes.NewReader(data.Bytes()), bytes.NewReader(index.Bytes()), tt.from, tt.to)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(read).To(Equal(tt.lines), "lines %d to %d", tt.from, tt.to)
+	}
+}
+
+func TestFileLines_Timestamps(t *testing.T) {
+	g := NewGomegaWithT(t)
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var data, index bytes.Buffer
+	lines := ansi.NewFileLines(&data, &index)
+	writer := ansi.NewWriter(lines, ansi.WithTimestamps(func() time.Time { return start }, time.Second))
+
+	_, err := writer.WriteString("one\ntwo")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(writer.Close()).To(Succeed())
+
+	read, err := ansi.ReadFileLines(bytes.NewReader(data.Bytes()), bytes.NewReader(index.Bytes()), 0, 2)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(read).To(Equal(ansi.Lines{
+		{{Data: ansi.Text("one"), Time: &start}},
+		{{Data: ansi.Text("two"), Time: &start}},
+	}))
+}
@@ -6,3 +6,27 @@ type Output interface {
 	Print(data []byte, style Style, pos Pos) error
 	ClearRight(pos Pos) error
 }
+
+// WrapOutput is implemented by Outputs that want to know which lines were
+// soft-wrapped by autowrap rather than ended by a linebreak, so the text can
+// be rejoined when rendered at another width.
+type WrapOutput interface {
+	MarkWrapped(line int) error
+}
+
+// ReflowOutput is implemented by Outputs that can rewrap their lines to a
+// new width, rejoining soft-wrapped lines first. Reflow returns where the
+// text at each of positions, such as the cursor, ended up; a column may be
+// cols when it's right past the end of a full line.
+type ReflowOutput interface {
+	Reflow(cols int, positions ...Pos) ([]Pos, error)
+}
+
+// ChunkOutput is implemented by Outputs that keep what a Chunk has on top of
+// the text and its style, such as which stream it came from. PrintChunk is
+// then called instead of Print and PrintImage: with Image set, chunk is an
+// image taking up a single cell, otherwise its Data is printed like Print's
+// data, and must not be retained either.
+type ChunkOutput interface {
+	PrintChunk(chunk Chunk, pos Pos) error
+}
@@ -1,8 +1,22 @@
 package ansi
 
-import "unicode/utf8"
+import (
+	"bytes"
+	"net/url"
+	"unicode/utf8"
+)
 
-const escapeCode = '\x1b'
+const (
+	escapeCode   = '\x1b'
+	shiftOutCode = '\x0e'
+	shiftInCode  = '\x0f'
+	bellCode     = '\x07'
+
+	// Caps how much of a control string (OSC, DCS or APC) gets buffered, so
+	// that an unterminated one can't make us allocate arbitrary amounts of
+	// memory. Inline images can take up a few megabytes.
+	maxStringLength = 1 << 24
+)
 
 type stateFn func(p *Parser, input []byte) stateFn
 
@@ -22,8 +36,10 @@ type Parser struct {
 	start int
 	pos   int
 
-	currNum maybeInt
-	nums    []maybeInt
+	currNum      maybeInt
+	nums         []maybeInt
+	private      byte
+	intermediate byte
 
 	state stateFn
 
@@ -31,6 +47,21 @@ type Parser struct {
 	action_i int
 
 	dangling []byte
+	// partial has the bytes of an escape sequence that was started in an
+	// earlier input, and inString is set while a control string is read.
+	partial      []byte
+	inString     bool
+	stringEscape bool
+
+	// The control string being read, and the byte that introduced it
+	str         []byte
+	strKind     byte
+	strOverflow bool
+	kittyImage  *kittyImage
+
+	// Compatibility decides how ambiguous parameters are read, ElmANSI by
+	// default.
+	Compatibility Compatibility
 }
 
 func NewParser() *Parser {
@@ -55,6 +86,10 @@ func (p *Parser) Parse(input []byte) (Action, []byte) {
 		p.state = p.state(p, input)
 	}
 	if len(p.actions) == 0 {
+		// The input ended in the middle of an escape sequence
+		if !p.inString {
+			p.partial = append(p.partial, input[p.start:p.pos]...)
+		}
 		return nil, nil
 	}
 	var rem []byte
@@ -80,10 +115,39 @@ func (p *Parser) extractDangling(input []byte) []byte {
 			break
 		}
 	}
-	p.dangling = input[len(input)-leftover:]
+	// Copied, as input may be reused by the caller
+	p.dangling = append([]byte(nil), input[len(input)-leftover:]...)
 	return input[:len(input)-leftover]
 }
 
+// Flush ends the input, returning what was left of it unfinished: the bytes
+// of an escape sequence that wasn't terminated, or of an incomplete UTF-8
+// character. The Parser then starts afresh.
+func (p *Parser) Flush() []byte {
+	var rest []byte
+	if p.inString {
+		rest = append([]byte{escapeCode, p.strKind}, p.str...)
+		if p.stringEscape {
+			rest = append(rest, escapeCode)
+		}
+	} else {
+		rest = append(rest, p.partial...)
+	}
+	rest = append(rest, p.dangling...)
+
+	p.state = parseBytes
+	p.partial = p.partial[:0]
+	p.inString = false
+	p.stringEscape = false
+	p.str = p.str[:0]
+	p.kittyImage = nil
+	p.dangling = nil
+	if len(rest) == 0 {
+		return nil
+	}
+	return rest
+}
+
 func (p *Parser) ParseAll(input []byte) []Action {
 	var actions []Action
 	for {
@@ -111,6 +175,7 @@ func (p *Parser) nextAction() Action {
 func (p *Parser) emit(action Action) {
 	p.actions = append(p.actions, action)
 	p.start = p.pos
+	p.partial = p.partial[:0]
 }
 
 func (p *Parser) print(input []byte) {
@@ -119,6 +184,7 @@ func (p *Parser) print(input []byte) {
 
 func (p *Parser) ignore() {
 	p.start = p.pos
+	p.partial = p.partial[:0]
 }
 
 func (p *Parser) next(input []byte) (byte, bool) {
@@ -146,19 +212,29 @@ func parseBytes(p *Parser, input []byte) stateFn {
 		switch c := p.peek(input); c {
 		case escapeCode:
 			if p.pos > p.start {
+				// The escape sequence is left for the next input, so that
+				// all of its bytes are in the same one
 				p.print(input)
+				return parseBytes
 			}
 			p.next(input)
 			return parseEscapeSequence
-		case '\n', '\r':
+		case '\n', '\r', shiftOutCode, shiftInCode, bellCode:
 			if p.pos > p.start {
 				p.print(input)
 			}
 			p.next(input)
-			if c == '\n' {
+			switch c {
+			case '\n':
 				p.emit(Linebreak{})
-			} else {
+			case '\r':
 				p.emit(CarriageReturn{})
+			case shiftOutCode:
+				p.emit(ShiftOut{})
+			case shiftInCode:
+				p.emit(ShiftIn{})
+			case bellCode:
+				p.emit(Bell{})
 			}
 			return parseBytes
 		}
@@ -175,16 +251,55 @@ func parseBytes(p *Parser, input []byte) stateFn {
 func parseEscapeSequence(p *Parser, input []byte) stateFn {
 	p.nums = p.nums[:0]
 	p.currNum = maybeInt{}
+	p.private = 0
+	p.intermediate = 0
 	next, ok := p.next(input)
 	if !ok {
 		return parseEscapeSequence
 	}
-	if next != '[' {
-		p.backup()
+	switch next {
+	case '[':
+		return parseControlSequence
+	case ']', 'P', '_':
+		p.str = p.str[:0]
+		p.strKind = next
+		p.strOverflow = false
+		p.inString = true
+		return parseControlString
+	case '(', ')':
+		p.intermediate = next
+		return parseCharsetDesignation
+	case 'c':
+		p.emit(FullReset{})
+		return parseBytes
+	case '7':
+		p.emit(SaveCursorPosition{})
+		return parseBytes
+	case '8':
+		p.emit(RestoreCursorPosition{})
+		return parseBytes
+	}
+	p.backup()
+	p.ignore()
+	return parseBytes
+}
+
+func parseCharsetDesignation(p *Parser, input []byte) stateFn {
+	final, ok := p.next(input)
+	if !ok {
+		return parseCharsetDesignation
+	}
+	charset, known := charsetDesignations[final]
+	if !known {
 		p.ignore()
 		return parseBytes
 	}
-	return parseControlSequence
+	g := 0
+	if p.intermediate == ')' {
+		g = 1
+	}
+	p.emit(SetCharset{G: g, Charset: charset})
+	return parseBytes
 }
 
 func parseControlSequence(p *Parser, input []byte) stateFn {
@@ -195,6 +310,10 @@ func parseControlSequence(p *Parser, input []byte) stateFn {
 		if !ok {
 			return parseControlSequence
 		}
+		if isPrivateMarker(d) && len(p.nums) == 0 && !p.currNum.valid && p.private == 0 {
+			p.private = d
+			continue
+		}
 		if !isDigit(d) {
 			break
 		}
@@ -217,6 +336,22 @@ func parseControlSequenceMode(p *Parser, input []byte) stateFn {
 	if len(p.nums) > 0 {
 		num = p.nums[len(p.nums)-1]
 	}
+	if isIntermediate(mode) {
+		// Intermediate bytes come right before the final byte
+		p.intermediate = mode
+		return parseControlSequenceMode
+	}
+	if p.intermediate != 0 {
+		if p.intermediate == '!' && mode == 'p' {
+			p.emit(SoftReset{})
+		} else {
+			p.ignore()
+		}
+		return parseBytes
+	}
+	if p.private != 0 {
+		return parsePrivateMode(p, mode)
+	}
 	switch mode {
 	case 'm':
 		anyOk := false
@@ -225,7 +360,7 @@ func parseControlSequenceMode(p *Parser, input []byte) stateFn {
 			// If the final parameter is not specified, and it's not the first, don't reset
 			// e.g. "\x1b[m" and "\x1b[1;0m" reset, but "\x1b[1;m" sets to bold only (no reset)
 			// Not sure where this is in the spec, but it's how iTerm handles it
-			if !first && len(codes) == 1 && !codes[0].valid {
+			if p.Compatibility == ElmANSI && !first && len(codes) == 1 && !codes[0].valid {
 				break
 			}
 
@@ -241,24 +376,32 @@ func parseControlSequenceMode(p *Parser, input []byte) stateFn {
 			return parseBytes
 		}
 	case 'A':
-		p.emit(CursorUp(num.withDefault(1)))
+		p.emit(CursorUp(p.Compatibility.count(num)))
 	case 'B':
-		p.emit(CursorDown(num.withDefault(1)))
+		p.emit(CursorDown(p.Compatibility.count(num)))
 	case 'C':
-		p.emit(CursorForward(num.withDefault(1)))
+		p.emit(CursorForward(p.Compatibility.count(num)))
 	case 'D':
-		p.emit(CursorBack(num.withDefault(1)))
+		p.emit(CursorBack(p.Compatibility.count(num)))
 	case 'E':
-		p.emit(CursorDown(num.withDefault(1)))
+		p.emit(CursorDown(p.Compatibility.count(num)))
 		p.emit(CursorColumn(0))
 	case 'F':
-		p.emit(CursorUp(num.withDefault(1)))
+		p.emit(CursorUp(p.Compatibility.count(num)))
 		p.emit(CursorColumn(0))
-	case 'G':
-		// This *should* be 1 according to https://en.wikipedia.org/wiki/ANSI_escape_code#Terminal_output_sequences
-		// but to match vito/elm-ansi, use 0
-		// Note that 0 and 1 seem to behave in the same way
-		p.emit(CursorColumn(num.withDefault(0)))
+	case 'G', '`':
+		// The default is 1 according to https://en.wikipedia.org/wiki/ANSI_escape_code#Terminal_output_sequences
+		// but the compatibility profile decides: ElmANSI goes to column 0 to
+		// match vito/elm-ansi, the others read it as 1-based
+		p.emit(CursorColumn(p.Compatibility.position(num, 0)))
+	case 'd':
+		p.emit(CursorLine(p.Compatibility.position(num, 1)))
+	case 'a':
+		p.emit(CursorForward(p.Compatibility.count(num)))
+	case 'e':
+		p.emit(CursorDown(p.Compatibility.count(num)))
+	case 'b':
+		p.emit(Repeat(p.Compatibility.count(num)))
 	case 'H', 'f':
 		var (
 			firstNum  maybeInt
@@ -271,13 +414,35 @@ func parseControlSequenceMode(p *Parser, input []byte) stateFn {
 			secondNum = p.nums[1]
 		}
 		p.emit(CursorPosition(Pos{
-			Line: firstNum.withDefault(1),
-			Col:  secondNum.withDefault(1),
+			Line: p.Compatibility.position(firstNum, 1),
+			Col:  p.Compatibility.position(secondNum, 1),
 		}))
-	case 's':
-		p.emit(SaveCursorPosition{})
-	case 'u':
-		p.emit(RestoreCursorPosition{})
+	case 's', 'u':
+		// SCOSC and SCORC aren't part of ECMA-48
+		if !p.Compatibility.extensions() {
+			p.ignore()
+			return parseBytes
+		}
+		if mode == 's' {
+			p.emit(SaveCursorPosition{})
+		} else {
+			p.emit(RestoreCursorPosition{})
+		}
+	case 'n':
+		switch num.withDefault(0) {
+		case 5:
+			p.emit(ReportStatus{})
+		case 6:
+			p.emit(ReportCursorPosition{})
+		default:
+			p.ignore()
+		}
+	case 'c':
+		if num.withDefault(0) != 0 {
+			p.ignore()
+			return parseBytes
+		}
+		p.emit(ReportDeviceAttributes{})
 	case 'J':
 		p.emit(EraseDisplay(num.withDefault(0)))
 	case 'K':
@@ -292,6 +457,286 @@ func parseControlSequenceMode(p *Parser, input []byte) stateFn {
 	return parseBytes
 }
 
+// parsePrivateMode handles sequences introduced by a private marker, e.g.
+// "\x1b[?7h". Only DEC private modes are recognised, everything else is dropped.
+func parsePrivateMode(p *Parser, mode byte) stateFn {
+	if mode == ';' {
+		return parseControlSequence
+	}
+	if p.private == '>' && mode == 'c' && p.Compatibility.extensions() {
+		p.emit(ReportDeviceAttributes{Secondary: true})
+		return parseBytes
+	}
+	if p.private != '?' || (mode != 'h' && mode != 'l') {
+		p.ignore()
+		return parseBytes
+	}
+	anyOk := false
+	for _, n := range p.nums {
+		if action := decPrivateMode(n.withDefault(0), mode == 'h', p.Compatibility); action != nil {
+			p.emit(action)
+			anyOk = true
+		}
+	}
+	if !anyOk {
+		p.ignore()
+	}
+	return parseBytes
+}
+
+func decPrivateMode(mode int, enabled bool, compat Compatibility) Action {
+	if mode == 7 {
+		return SetAutowrap(enabled)
+	}
+	if !compat.extensions() {
+		return nil
+	}
+	switch mode {
+	case 47, 1047:
+		return SetAlternateScreen{Enabled: enabled}
+	case 1049:
+		return SetAlternateScreen{Enabled: enabled, SaveCursor: true}
+	}
+	return nil
+}
+
+// parseControlString buffers an OSC ("\x1b]..."), DCS ("\x1bP...") or APC
+// ("\x1b_...") until it's terminated by ST ("\x1b\\"), or BEL for an OSC, as
+// it may be split across inputs.
+func parseControlString(p *Parser, input []byte) stateFn {
+	rest := input[p.pos:]
+	var end int
+	if p.strKind == ']' {
+		end = bytes.IndexAny(rest, "\x07\x1b")
+	} else {
+		end = bytes.IndexByte(rest, escapeCode)
+	}
+	if end < 0 {
+		p.bufferString(rest)
+		p.pos = len(input)
+		p.ignore()
+		return parseControlString
+	}
+	p.bufferString(rest[:end])
+	p.pos += end + 1
+	if rest[end] == escapeCode {
+		p.stringEscape = true
+		return parseControlStringEscape
+	}
+	p.dispatchString()
+	return parseBytes
+}
+
+func parseControlStringEscape(p *Parser, input []byte) stateFn {
+	if p.peek(input) != '\\' {
+		if p.pos >= len(input) {
+			return parseControlStringEscape
+		}
+		p.stringEscape = false
+		// Any other escape sequence cancels the control string
+		p.ignore()
+		p.inString = false
+		p.partial = append(p.partial, escapeCode)
+		return parseEscapeSequence
+	}
+	p.next(input)
+	p.stringEscape = false
+	p.dispatchString()
+	return parseBytes
+}
+
+func (p *Parser) bufferString(data []byte) {
+	if p.strOverflow || len(p.str)+len(data) > maxStringLength {
+		p.str = p.str[:0]
+		p.strOverflow = true
+		return
+	}
+	p.str = append(p.str, data...)
+}
+
+func (p *Parser) dispatchString() {
+	p.inString = false
+	if p.strOverflow {
+		p.ignore()
+		return
+	}
+	switch p.strKind {
+	case ']':
+		p.dispatchOSC()
+	case 'P':
+		p.dispatchDCS()
+	case '_':
+		p.dispatchAPC()
+	}
+}
+
+// dispatchOSC emits the actions for the OSC that was just terminated. The
+// command is a number followed by its arguments, e.g. "\x1b]11;?\x07".
+func (p *Parser) dispatchOSC() {
+	command, args := p.str, []byte(nil)
+	if i := bytes.IndexByte(command, ';'); i >= 0 {
+		command, args = command[:i], command[i+1:]
+	}
+	code, ok := atoi(command)
+	if !ok {
+		p.ignore()
+		return
+	}
+	if !oscActions(p, code, args) {
+		p.emit(OperatingSystemCommand{Code: code, Args: string(args)})
+	}
+}
+
+// oscActions emits the actions for OSC code, returning whether there were any.
+func oscActions(p *Parser, code int, args []byte) bool {
+	if !p.Compatibility.extensions() {
+		return false
+	}
+	anyOk := false
+	switch code {
+	case 0, 1, 2:
+		if code != 2 {
+			p.emit(SetIconName(args))
+		}
+		if code != 1 {
+			p.emit(SetWindowTitle(args))
+		}
+		anyOk = true
+	case 7:
+		p.emit(SetWorkingDirectory(workingDirectory(args)))
+		anyOk = true
+	case 9:
+		// ConEmu uses "\x1b]9;n;...\x07" for other things than notifications
+		if i := bytes.IndexByte(args, ';'); i > 0 {
+			if _, numbered := atoi(args[:i]); numbered {
+				return false
+			}
+		}
+		p.emit(Notify{Body: string(args)})
+		anyOk = true
+	case 777:
+		fields := bytes.SplitN(args, []byte{';'}, 3)
+		if string(fields[0]) != "notify" {
+			return false
+		}
+		notify := Notify{}
+		if len(fields) > 1 {
+			notify.Title = string(fields[1])
+		}
+		if len(fields) > 2 {
+			notify.Body = string(fields[2])
+		}
+		p.emit(notify)
+		anyOk = true
+	case 1337:
+		image, ok := parseITermImage(args)
+		if !ok {
+			return false
+		}
+		p.emit(InlineImage(image))
+		anyOk = true
+	case 133:
+		if len(args) == 0 {
+			break
+		}
+		mark := CommandMark{Kind: CommandMarkKind(args[0])}
+		switch mark.Kind {
+		case PromptStart, CommandStart, CommandExecuted:
+		case CommandFinished:
+			if len(args) > 2 && args[1] == ';' {
+				exitCode := args[2:]
+				if i := bytes.IndexByte(exitCode, ';'); i >= 0 {
+					exitCode = exitCode[:i]
+				}
+				mark.ExitCode, mark.HasExitCode = atoi(exitCode)
+			}
+		default:
+			return false
+		}
+		p.emit(mark)
+		anyOk = true
+	case 4:
+		fields := bytes.Split(args, []byte{';'})
+		for i := 0; i+1 < len(fields); i += 2 {
+			index, ok := atoi(fields[i])
+			if !ok || index > 255 || string(fields[i+1]) != "?" {
+				continue
+			}
+			p.emit(ReportPaletteColor(index))
+			anyOk = true
+		}
+	case 10, 11:
+		if string(args) == "?" {
+			if code == 10 {
+				p.emit(ReportForeground{})
+			} else {
+				p.emit(ReportBackground{})
+			}
+			anyOk = true
+		}
+	}
+	return anyOk
+}
+
+// dispatchDCS emits the actions for the DCS that was just terminated, of
+// which only sixel images ("\x1bPq...") are understood.
+func (p *Parser) dispatchDCS() {
+	image, ok := parseSixelImage(p.str)
+	if !ok {
+		p.ignore()
+		return
+	}
+	p.emit(InlineImage(image))
+}
+
+// dispatchAPC emits the actions for the APC that was just terminated, of
+// which only kitty graphics ("\x1b_G...") are understood.
+func (p *Parser) dispatchAPC() {
+	if len(p.str) == 0 || p.str[0] != 'G' {
+		p.ignore()
+		return
+	}
+	image, ok := p.kittyGraphics(p.str[1:])
+	if !ok {
+		p.ignore()
+		return
+	}
+	p.emit(InlineImage(image))
+}
+
+// workingDirectory is the path of an OSC 7 file URL, e.g.
+// "file://host/home/user". Anything that isn't a URL is taken as a path.
+func workingDirectory(args []byte) string {
+	u, err := url.Parse(string(args))
+	if err != nil || u.Scheme == "" {
+		return string(args)
+	}
+	return u.Path
+}
+
+// atoi parses a non-negative decimal number.
+func atoi(data []byte) (int, bool) {
+	if len(data) == 0 || len(data) > 9 {
+		return 0, false
+	}
+	n := 0
+	for _, d := range data {
+		if !isDigit(d) {
+			return 0, false
+		}
+		n = 10*n + int(d-'0')
+	}
+	return n, true
+}
+
+func isPrivateMarker(c byte) bool {
+	return c >= '<' && c <= '?'
+}
+
+func isIntermediate(c byte) bool {
+	return c >= ' ' && c <= '/'
+}
+
 func isDigit(c byte) bool {
 	return c >= '0' && c <= '9'
 }
@@ -1,7 +1,13 @@
 package ansi_test
 
 import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/cowdude/ansi"
 	. "github.com/onsi/gomega"
@@ -237,6 +243,30 @@ func TestParser_Actions(t *testing.T) {
 				ansi.CursorColumn(50),
 			},
 		},
+		{
+			description: "cursor movement (absolute/relative line and column)",
+			input:       []byte("\x1b[d\x1b[5d\x1b[`\x1b[5`\x1b[a\x1b[5a\x1b[e\x1b[5e"),
+			actions: []ansi.Action{
+				ansi.CursorLine(1),
+				ansi.CursorLine(5),
+				ansi.CursorColumn(0),
+				ansi.CursorColumn(5),
+				ansi.CursorForward(1),
+				ansi.CursorForward(5),
+				ansi.CursorDown(1),
+				ansi.CursorDown(5),
+			},
+		},
+		{
+			description: "repeat",
+			input:       []byte("-\x1b[b-\x1b[79b"),
+			actions: []ansi.Action{
+				ansi.Print("-"),
+				ansi.Repeat(1),
+				ansi.Print("-"),
+				ansi.Repeat(79),
+			},
+		},
 		{
 			description: "save/restore cursor",
 			input:       []byte("\x1b[s\x1b[u"),
@@ -245,6 +275,29 @@ func TestParser_Actions(t *testing.T) {
 				ansi.RestoreCursorPosition{},
 			},
 		},
+		{
+			description: "save/restore cursor (DECSC/DECRC)",
+			input:       []byte("\x1b7\x1b8"),
+			actions: []ansi.Action{
+				ansi.SaveCursorPosition{},
+				ansi.RestoreCursorPosition{},
+			},
+		},
+		{
+			description: "charsets",
+			input:       []byte("\x1b(0lqk\x1b(B\x1b)A\x0e#\x0f#\x1b(Zok"),
+			actions: []ansi.Action{
+				ansi.SetCharset{G: 0, Charset: ansi.CharsetDECSpecialGraphics},
+				ansi.Print("lqk"),
+				ansi.SetCharset{G: 0, Charset: ansi.CharsetASCII},
+				ansi.SetCharset{G: 1, Charset: ansi.CharsetUK},
+				ansi.ShiftOut{},
+				ansi.Print("#"),
+				ansi.ShiftIn{},
+				ansi.Print("#"),
+				ansi.Print("ok"),
+			},
+		},
 		{
 			description: "erasure",
 			input:       []byte("\x1b[J\x1b[0J\x1b[1J\x1b[2J\x1b[K\x1b[0K\x1b[1K\x1b[2K"),
@@ -259,6 +312,26 @@ func TestParser_Actions(t *testing.T) {
 				ansi.EraseLine(ansi.EraseAll),
 			},
 		},
+		{
+			description: "autowrap mode",
+			input:       []byte("\x1b[?7l\x1b[?7h\x1b[?25;7lhidden\x1b[7h\x1b[?25l"),
+			actions: []ansi.Action{
+				ansi.SetAutowrap(false),
+				ansi.SetAutowrap(true),
+				ansi.SetAutowrap(false),
+				ansi.Print("hidden"),
+			},
+		},
+		{
+			description: "alternate screen",
+			input:       []byte("\x1b[?1049h\x1b[?1049l\x1b[?47h\x1b[?1047l"),
+			actions: []ansi.Action{
+				ansi.SetAlternateScreen{Enabled: true, SaveCursor: true},
+				ansi.SetAlternateScreen{Enabled: false, SaveCursor: true},
+				ansi.SetAlternateScreen{Enabled: true},
+				ansi.SetAlternateScreen{Enabled: false},
+			},
+		},
 		{
 			description: "incomplete escape sequence (no bracket)",
 			input:       []byte("hello\x1bworld"),
@@ -298,6 +371,131 @@ func TestParser_Actions(t *testing.T) {
 				ansi.Linebreak{},
 			},
 		},
+		{
+			description: "device status and attribute queries",
+			input:       []byte("\x1b[5n\x1b[6n\x1b[c\x1b[0c\x1b[>c\x1b[7n"),
+			actions: []ansi.Action{
+				ansi.ReportStatus{},
+				ansi.ReportCursorPosition{},
+				ansi.ReportDeviceAttributes{},
+				ansi.ReportDeviceAttributes{},
+				ansi.ReportDeviceAttributes{Secondary: true},
+			},
+		},
+		{
+			description: "colour queries",
+			input:       []byte("a\x1b]10;?\x07b\x1b]11;?\x1b\\c\x1b]4;1;?;300;?;17;?\x07"),
+			actions: []ansi.Action{
+				ansi.Print("a"),
+				ansi.ReportForeground{},
+				ansi.Print("b"),
+				ansi.ReportBackground{},
+				ansi.Print("c"),
+				ansi.ReportPaletteColor(1),
+				ansi.ReportPaletteColor(17),
+			},
+		},
+		{
+			description: "titles and working directory",
+			input:       []byte("\x1b]0;both\x07\x1b]1;icon\x07\x1b]2;title\x1b\\\x1b]7;file://host/home/me%20too\x07"),
+			actions: []ansi.Action{
+				ansi.SetIconName("both"),
+				ansi.SetWindowTitle("both"),
+				ansi.SetIconName("icon"),
+				ansi.SetWindowTitle("title"),
+				ansi.SetWorkingDirectory("/home/me too"),
+			},
+		},
+		{
+			description: "shell integration marks",
+			input:       []byte("\x1b]133;A\x07$ \x1b]133;B\x07ls\n\x1b]133;C\x07\x1b]133;D;1\x07\x1b]133;D\x07\x1b]133;Z\x07"),
+			actions: []ansi.Action{
+				ansi.CommandMark{Kind: ansi.PromptStart},
+				ansi.Print("$ "),
+				ansi.CommandMark{Kind: ansi.CommandStart},
+				ansi.Print("ls"),
+				ansi.Linebreak{},
+				ansi.CommandMark{Kind: ansi.CommandExecuted},
+				ansi.CommandMark{Kind: ansi.CommandFinished, ExitCode: 1, HasExitCode: true},
+				ansi.CommandMark{Kind: ansi.CommandFinished},
+				ansi.OperatingSystemCommand{Code: 133, Args: "Z"},
+			},
+		},
+		{
+			description: "inline images",
+			input: []byte("\x1b]1337;File=name=YS5wbmc=;width=10;height=20px;inline=1:aGk=\x07" +
+				"\x1b]1337;File=name=YS5wbmc=:aGk=\x07" +
+				"\x1b_Ga=T,f=100,c=4,m=1;aGVs\x1b\\\x1b_Gm=0;bG8=\x1b\\" +
+				"\x1b_Ga=t,f=100;aGk=\x1b\\" +
+				"\x1bPq\"1;1;6;4#0!6~\x1b\\"),
+			actions: []ansi.Action{
+				ansi.InlineImage{
+					Protocol:    ansi.ITermImages,
+					Name:        "a.png",
+					Data:        []byte("hi"),
+					Width:       10,
+					PixelHeight: 20,
+				},
+				ansi.OperatingSystemCommand{Code: 1337, Args: "File=name=YS5wbmc=:aGk="},
+				ansi.InlineImage{
+					Protocol: ansi.KittyImages,
+					Format:   "image/png",
+					Data:     []byte("hello"),
+					Width:    4,
+				},
+				ansi.InlineImage{
+					Protocol:    ansi.SixelImages,
+					Format:      "sixel",
+					Data:        []byte("\"1;1;6;4#0!6~"),
+					PixelWidth:  6,
+					PixelHeight: 4,
+				},
+			},
+		},
+		{
+			description: "bells and notifications",
+			input:       []byte("done\x07\x1b]9;Build finished\x07\x1b]9;4;1;50\x07\x1b]777;notify;CI;Tests failed\x07"),
+			actions: []ansi.Action{
+				ansi.Print("done"),
+				ansi.Bell{},
+				ansi.Notify{Body: "Build finished"},
+				ansi.OperatingSystemCommand{Code: 9, Args: "4;1;50"},
+				ansi.Notify{Title: "CI", Body: "Tests failed"},
+			},
+		},
+		{
+			description: "resets",
+			input:       []byte("a\x1bcb\x1b[!pc\x1b[2 qd"),
+			actions: []ansi.Action{
+				ansi.Print("a"),
+				ansi.FullReset{},
+				ansi.Print("b"),
+				ansi.SoftReset{},
+				ansi.Print("c"),
+				ansi.Print("d"),
+			},
+		},
+		{
+			description: "unknown operating system commands are passed on",
+			input:       []byte("a\x1b]11;rgb:0000/0000/0000\x07b\x1b]999\x1b\\c\x1b]x;y\x07d"),
+			actions: []ansi.Action{
+				ansi.Print("a"),
+				ansi.OperatingSystemCommand{Code: 11, Args: "rgb:0000/0000/0000"},
+				ansi.Print("b"),
+				ansi.OperatingSystemCommand{Code: 999},
+				ansi.Print("c"),
+				ansi.Print("d"),
+			},
+		},
+		{
+			description: "operating system commands are cancelled by another escape sequence",
+			input:       []byte("a\x1b]11;?\x1b[1mb"),
+			actions: []ansi.Action{
+				ansi.Print("a"),
+				ansi.SetBold(true),
+				ansi.Print("b"),
+			},
+		},
 	} {
 		t.Run(tt.description, func(t *testing.T) {
 			g := NewGomegaWithT(t)
@@ -310,6 +508,35 @@ func TestParser_Actions(t *testing.T) {
 	}
 }
 
+func TestParser_KittyImageTooLarge(t *testing.T) {
+	g := NewGomegaWithT(t)
+	compress := func(size int) string {
+		var compressed bytes.Buffer
+		w := zlib.NewWriter(&compressed)
+		zeros := make([]byte, 1<<20)
+		for size > 0 {
+			n := len(zeros)
+			if size < n {
+				n = size
+			}
+			w.Write(zeros[:n])
+			size -= n
+		}
+		w.Close()
+		return base64.StdEncoding.EncodeToString(compressed.Bytes())
+	}
+
+	p := ansi.NewParser()
+	actions := p.ParseAll([]byte("\x1b_Ga=T,o=z;" + compress(1<<26) + "\x1b\\"))
+	g.Expect(actions).To(HaveLen(1))
+	g.Expect(actions[0].(ansi.InlineImage).Data).To(HaveLen(1 << 26))
+
+	// An image that decompresses past the limit is dropped rather than cut
+	// short
+	actions = p.ParseAll([]byte("\x1b_Ga=T,o=z;" + compress(1<<26+1) + "\x1b\\"))
+	g.Expect(actions).To(BeEmpty())
+}
+
 func TestParser_Carryover(t *testing.T) {
 	format.UseStringerRepresentation = true
 
@@ -407,6 +634,30 @@ func TestParser_Carryover(t *testing.T) {
 				ansi.Print("green and bold"),
 			},
 		},
+		{
+			description: "partial private mode",
+			inputs: [][]byte{
+				[]byte("hello\x1b[?"),
+				[]byte("7lno wrap"),
+			},
+			actions: []ansi.Action{
+				ansi.Print("hello"),
+				ansi.SetAutowrap(false),
+				ansi.Print("no wrap"),
+			},
+		},
+		{
+			description: "partial charset designation",
+			inputs: [][]byte{
+				[]byte("hello\x1b("),
+				[]byte("0q"),
+			},
+			actions: []ansi.Action{
+				ansi.Print("hello"),
+				ansi.SetCharset{G: 0, Charset: ansi.CharsetDECSpecialGraphics},
+				ansi.Print("q"),
+			},
+		},
 		{
 			description: "incomplete rune",
 			inputs: [][]byte{
@@ -428,6 +679,20 @@ func TestParser_Carryover(t *testing.T) {
 				ansi.Print("こ"),
 			},
 		},
+		{
+			description: "operating system command split across inputs",
+			inputs: [][]byte{
+				[]byte("a\x1b]1"),
+				[]byte("1;"),
+				[]byte("?\x1b"),
+				[]byte("\\b"),
+			},
+			actions: []ansi.Action{
+				ansi.Print("a"),
+				ansi.ReportBackground{},
+				ansi.Print("b"),
+			},
+		},
 	} {
 		t.Run(tt.description, func(t *testing.T) {
 			g := NewGomegaWithT(t)
@@ -442,3 +707,245 @@ func TestParser_Carryover(t *testing.T) {
 		})
 	}
 }
+
+func TestParser_Compatibility(t *testing.T) {
+	format.UseStringerRepresentation = true
+
+	for _, tt := range []struct {
+		description   string
+		compatibility ansi.Compatibility
+		input         []byte
+		actions       []ansi.Action
+	}{
+		{
+			description:   "elm-ansi cursor position is used as-is",
+			compatibility: ansi.ElmANSI,
+			input:         []byte("\x1b[H\x1b[3;5H"),
+			actions: []ansi.Action{
+				ansi.CursorPosition(ansi.Pos{Line: 1, Col: 1}),
+				ansi.CursorPosition(ansi.Pos{Line: 3, Col: 5}),
+			},
+		},
+		{
+			description:   "ecma-48 cursor position is 1-based",
+			compatibility: ansi.ECMA48,
+			input:         []byte("\x1b[H\x1b[3;5H\x1b[0;0H"),
+			actions: []ansi.Action{
+				ansi.CursorPosition(ansi.Pos{Line: 0, Col: 0}),
+				ansi.CursorPosition(ansi.Pos{Line: 2, Col: 4}),
+				ansi.CursorPosition(ansi.Pos{Line: 0, Col: 0}),
+			},
+		},
+		{
+			description:   "elm-ansi column and line are used as-is",
+			compatibility: ansi.ElmANSI,
+			input:         []byte("\x1b[G\x1b[4G\x1b[d"),
+			actions: []ansi.Action{
+				ansi.CursorColumn(0),
+				ansi.CursorColumn(4),
+				ansi.CursorLine(1),
+			},
+		},
+		{
+			description:   "xterm column and line are 1-based",
+			compatibility: ansi.XTerm,
+			input:         []byte("\x1b[G\x1b[4G\x1b[d"),
+			actions: []ansi.Action{
+				ansi.CursorColumn(0),
+				ansi.CursorColumn(3),
+				ansi.CursorLine(0),
+			},
+		},
+		{
+			description:   "elm-ansi zero counts",
+			compatibility: ansi.ElmANSI,
+			input:         []byte("\x1b[0A\x1b[0b"),
+			actions: []ansi.Action{
+				ansi.CursorUp(0),
+				ansi.Repeat(0),
+			},
+		},
+		{
+			description:   "xterm zero counts default to 1",
+			compatibility: ansi.XTerm,
+			input:         []byte("\x1b[0A\x1b[0b"),
+			actions: []ansi.Action{
+				ansi.CursorUp(1),
+				ansi.Repeat(1),
+			},
+		},
+		{
+			description:   "elm-ansi trailing empty SGR parameter",
+			compatibility: ansi.ElmANSI,
+			input:         []byte("\x1b[1;m"),
+			actions: []ansi.Action{
+				ansi.SetBold(true),
+			},
+		},
+		{
+			description:   "xterm trailing empty SGR parameter resets",
+			compatibility: ansi.XTerm,
+			input:         []byte("\x1b[1;m"),
+			actions: []ansi.Action{
+				ansi.SetBold(true),
+				ansi.Reset{},
+			},
+		},
+		{
+			description:   "xterm extensions",
+			compatibility: ansi.XTerm,
+			input:         []byte("\x1b[s\x1b[?1049h\x1b[?7l"),
+			actions: []ansi.Action{
+				ansi.SaveCursorPosition{},
+				ansi.SetAlternateScreen{Enabled: true, SaveCursor: true},
+				ansi.SetAutowrap(false),
+			},
+		},
+		{
+			description:   "ecma-48 ignores xterm extensions",
+			compatibility: ansi.ECMA48,
+			input:         []byte("a\x1b[sb\x1b[?1049hc\x1b[?7l\x1b[>c\x1b]11;?\x07"),
+			actions: []ansi.Action{
+				ansi.Print("a"),
+				ansi.Print("b"),
+				ansi.Print("c"),
+				ansi.SetAutowrap(false),
+				ansi.OperatingSystemCommand{Code: 11, Args: "?"},
+			},
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			p := ansi.NewParser()
+			p.Compatibility = tt.compatibility
+
+			actions := p.ParseAll(tt.input)
+
+			g.Expect(actions).To(Equal(tt.actions))
+		})
+	}
+}
+
+func TestParser_Flush(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		inputs      [][]byte
+		rest        []byte
+	}{
+		{
+			description: "nothing unfinished",
+			inputs:      [][]byte{[]byte("hello\x1b[1m")},
+		},
+		{
+			description: "unfinished control sequence",
+			inputs:      [][]byte{[]byte("hello\x1b[3")},
+			rest:        []byte("\x1b[3"),
+		},
+		{
+			description: "unfinished control sequence split across inputs",
+			inputs:      [][]byte{[]byte("hello\x1b["), []byte("12;"), []byte("3")},
+			rest:        []byte("\x1b[12;3"),
+		},
+		{
+			description: "unfinished operating system command",
+			inputs:      [][]byte{[]byte("\x1b]0;ti"), []byte("tle\x1b")},
+			rest:        []byte("\x1b]0;title\x1b"),
+		},
+		{
+			description: "cancelled operating system command",
+			inputs:      [][]byte{[]byte("\x1b]0;title\x1b"), []byte("[1")},
+			rest:        []byte("\x1b[1"),
+		},
+		{
+			description: "incomplete character",
+			inputs:      [][]byte{[]byte("h\xc3\xa9llo\xc3")},
+			rest:        []byte("\xc3"),
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			p := ansi.NewParser()
+			for _, input := range tt.inputs {
+				p.ParseAll(input)
+			}
+
+			g.Expect(p.Flush()).To(Equal(tt.rest))
+			g.Expect(p.Flush()).To(BeNil())
+			g.Expect(p.ParseAll([]byte("a"))).To(Equal([]ansi.Action{ansi.Print("a")}))
+		})
+	}
+}
+
+func TestActionReader(t *testing.T) {
+	format.UseStringerRepresentation = true
+
+	for _, tt := range []struct {
+		description string
+		policy      ansi.UnfinishedPolicy
+		input       string
+		actions     []ansi.Action
+		err         error
+	}{
+		{
+			description: "reads actions until EOF",
+			input:       "h\xc3\xa9\x1b[1mllo\x1b]0;title\x07\n",
+			actions: []ansi.Action{
+				ansi.Print("h"),
+				ansi.Print("é"),
+				ansi.SetBold(true),
+				ansi.Print("l"),
+				ansi.Print("l"),
+				ansi.Print("o"),
+				ansi.SetIconName("title"),
+				ansi.SetWindowTitle("title"),
+				ansi.Linebreak{},
+			},
+			err: io.EOF,
+		},
+		{
+			description: "unfinished input is dropped",
+			input:       "a\x1b[3",
+			actions:     []ansi.Action{ansi.Print("a")},
+			err:         io.EOF,
+		},
+		{
+			description: "unfinished input can be printed",
+			policy:      ansi.PrintUnfinished,
+			input:       "a\x1b[3",
+			actions:     []ansi.Action{ansi.Print("a"), ansi.Print("\x1b[3")},
+			err:         io.EOF,
+		},
+		{
+			description: "unfinished input can be reported",
+			policy:      ansi.ReportUnfinished,
+			input:       "a\x1b[3",
+			actions:     []ansi.Action{ansi.Print("a")},
+			err:         ansi.ErrUnfinished,
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			r := ansi.NewActionReader(iotest.OneByteReader(strings.NewReader(tt.input)))
+			r.Unfinished = tt.policy
+
+			var (
+				actions []ansi.Action
+				err     error
+			)
+			for {
+				var action ansi.Action
+				if action, err = r.Read(); err != nil {
+					break
+				}
+				if print, ok := action.(ansi.Print); ok {
+					// Only valid until the next Read
+					action = ansi.Print(append([]byte(nil), print...))
+				}
+				actions = append(actions, action)
+			}
+
+			g.Expect(err).To(Equal(tt.err))
+			g.Expect(actions).To(Equal(tt.actions))
+		})
+	}
+}
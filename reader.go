@@ -0,0 +1,70 @@
+package ansi
+
+import "io"
+
+// Caps how many reads in a row may return nothing before giving up
+const maxEmptyReads = 100
+
+// ActionReader parses the actions of whatever is read from an io.Reader, a
+// chunk at a time.
+type ActionReader struct {
+	Parser *Parser
+	// Unfinished decides what happens to an unfinished escape sequence or
+	// character at the end of the input.
+	Unfinished UnfinishedPolicy
+
+	r     io.Reader
+	buf   []byte
+	input []byte
+	err   error
+}
+
+func NewActionReader(r io.Reader) *ActionReader {
+	return &ActionReader{
+		Parser: NewParser(),
+		r:      r,
+		buf:    make([]byte, copyBufferSize),
+	}
+}
+
+// Read returns the next action, or io.EOF once there are no more of them.
+//
+// The data of a Print is only valid until the next call to Read, as the
+// buffer it points into gets reused.
+func (a *ActionReader) Read() (Action, error) {
+	for empty := 0; ; {
+		if action, rest := a.Parser.Parse(a.input); action != nil {
+			a.input = rest
+			return action, nil
+		}
+		a.input = nil
+		if a.err != nil {
+			return a.finish()
+		}
+
+		n, err := a.r.Read(a.buf)
+		a.input, a.err = a.buf[:n], err
+		if n == 0 && err == nil {
+			if empty++; empty >= maxEmptyReads {
+				a.err = io.ErrNoProgress
+			}
+		}
+	}
+}
+
+func (a *ActionReader) finish() (Action, error) {
+	if a.err != io.EOF {
+		return nil, a.err
+	}
+	rest := a.Parser.Flush()
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	switch a.Unfinished {
+	case PrintUnfinished:
+		return Print(rest), nil
+	case ReportUnfinished:
+		return nil, ErrUnfinished
+	}
+	return nil, io.EOF
+}
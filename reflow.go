@@ -0,0 +1,125 @@
+package ansi
+
+// Reflow rewraps l to cols columns. Soft-wrapped lines are joined back
+// together first, and any line wider than cols gets soft-wrapped.
+func (l *Lines) Reflow(cols int, positions ...Pos) ([]Pos, error) {
+	newPositions := make([]Pos, len(positions))
+	copy(newPositions, positions)
+	if cols <= 0 {
+		return newPositions, nil
+	}
+
+	var (
+		newLines Lines
+		placed   = make([]bool, len(positions))
+		offsets  = make([]int, len(positions))
+	)
+	for start := 0; start < len(*l); {
+		end := start
+		for end < len(*l)-1 && l.isWrapped(end) {
+			end++
+		}
+
+		var (
+			logical Line
+			width   = 0
+		)
+		for j := range offsets {
+			offsets[j] = -1
+		}
+		for i := start; i <= end; i++ {
+			for j, pos := range positions {
+				if i == pos.Line {
+					offsets[j] = width + pos.Col
+				}
+			}
+			for _, chunk := range (*l)[i] {
+				chunk.Wrapped = false
+				// Make sure merging chunks never writes into shared memory
+				chunk.Data = chunk.Data[:len(chunk.Data):len(chunk.Data)]
+				width += textWidth(chunk.Data)
+				logical = appendChunk(logical, chunk)
+			}
+		}
+
+		wrapped := wrapLine(logical, cols)
+		for j, offset := range offsets {
+			if offset >= 0 {
+				newPositions[j] = cursorAfterWrap(wrapped, offset, cols)
+				newPositions[j].Line += len(newLines)
+				placed[j] = true
+			}
+		}
+		newLines = append(newLines, wrapped...)
+		start = end + 1
+	}
+	for j, pos := range positions {
+		if !placed[j] {
+			// Positions below the last line keep their distance from it
+			newPositions[j].Line = len(newLines) + pos.Line - len(*l)
+		}
+	}
+
+	*l = newLines
+	return newPositions, nil
+}
+
+// wrapLine splits line into lines of at most cols columns, marking all but
+// the last one as wrapped.
+func wrapLine(line Line, cols int) Lines {
+	var (
+		lines   Lines
+		current Line
+		width   = 0
+	)
+	for _, chunk := range line {
+		data := chunk.Data
+		for len(data) > 0 {
+			fits, rest := splitWidth(data, cols-width)
+			if len(fits) == 0 && width == 0 {
+				// A single character wider than the whole line
+				size, _ := nextCluster(data)
+				fits, rest = data[:size], data[size:]
+			}
+			if len(fits) > 0 {
+				fits = fits[:len(fits):len(fits)]
+				piece := chunk
+				piece.Data, piece.Wrapped = fits, false
+				current = append(current, piece)
+				width += textWidth(fits)
+			}
+			if len(rest) > 0 {
+				current[len(current)-1].Wrapped = true
+				lines = append(lines, current)
+				current, width = nil, 0
+			}
+			data = rest
+		}
+	}
+	if current == nil {
+		current = Line{}
+	}
+	return append(lines, current)
+}
+
+// cursorAfterWrap finds where the cell at offset in the unwrapped line ended
+// up in lines.
+func cursorAfterWrap(lines Lines, offset, cols int) Pos {
+	for i, line := range lines {
+		width := 0
+		for _, chunk := range line {
+			width += textWidth(chunk.Data)
+		}
+		if i == len(lines)-1 {
+			break
+		}
+		if offset < width || (offset == width && width < cols) {
+			return Pos{Line: i, Col: offset}
+		}
+		offset -= width
+	}
+	if offset > cols {
+		offset = cols
+	}
+	return Pos{Line: len(lines) - 1, Col: offset}
+}
@@ -0,0 +1,62 @@
+package ansi
+
+import (
+	"fmt"
+	"io"
+)
+
+// report answers a query found in the input by writing to Responses, the
+// way a terminal would answer the program it is running.
+func (w *Writer) report(act Action) error {
+	if w.Responses == nil {
+		return nil
+	}
+	var response string
+	switch v := act.(type) {
+	case ReportStatus:
+		response = "\x1b[0n"
+	case ReportCursorPosition:
+		// Always 1-based and relative to the top of the screen, like a
+		// terminal reports it, whatever the compatibility profile
+		response = fmt.Sprintf("\x1b[%d;%dR",
+			w.Position.Line-w.screenTop()+1,
+			w.Position.Col+1)
+	case ReportDeviceAttributes:
+		switch {
+		case v.Secondary:
+			response = "\x1b[>1;10;0c"
+		case w.Parser.Compatibility == ECMA48:
+			// VT100 with advanced video option
+			response = "\x1b[?1;2c"
+		default:
+			// VT220 with ANSI colours
+			response = "\x1b[?62;22c"
+		}
+	case ReportForeground:
+		response = "\x1b]10;" + rgbSpec(DefaultColor, w.Palette) + "\x1b\\"
+	case ReportBackground:
+		response = "\x1b]11;" + rgbSpec(w.DefaultBackground, w.Palette) + "\x1b\\"
+	case ReportPaletteColor:
+		color := Color8Indexed(uint8(v))
+		response = fmt.Sprintf("\x1b]4;%d;%s\x1b\\", v, rgbSpec(color, w.Palette))
+	default:
+		return nil
+	}
+	_, err := io.WriteString(w.Responses, response)
+	return err
+}
+
+// rgbSpec formats c the way xterm reports colours, e.g. "rgb:ffff/0000/0000".
+func rgbSpec(c Color, palette ColorPalette) string {
+	r, g, b, _ := c.PaletteRGBA(palette)
+	return fmt.Sprintf("rgb:%04x/%04x/%04x", r, g, b)
+}
+
+// WithResponses makes the Writer answer queries such as cursor position
+// reports and device attributes by writing to responses, which would usually
+// be the PTY the output is read from.
+func WithResponses(responses io.Writer) WriterOption {
+	return func(w *Writer) {
+		w.Responses = responses
+	}
+}
@@ -0,0 +1,51 @@
+package ansi
+
+// softReset is DECSTR: the style, autowrap, charsets and saved cursor go
+// back to what the Writer was configured with, but the cursor stays put. Like
+// on a VT220, the line discipline (LNM) is left as it is.
+func (w *Writer) softReset() {
+	w.Style = w.defaults.Style
+	w.Charsets = w.defaults.Charsets
+	w.setSavedCursor(nil)
+	w.Autowrap = w.defaults.Autowrap
+	w.PendingWrap = false
+}
+
+// fullReset is RIS. On top of what softReset does, it resets the line
+// discipline, switches back to the main screen, throwing away whatever was
+// drawn on the alternate one, and either clears the screen, with
+// ClearOnReset, or moves the cursor to the start of the next line so that
+// nothing gets overwritten.
+func (w *Writer) fullReset() error {
+	if err := w.exitAltScreen(false, DiscardAltScreen); err != nil {
+		return err
+	}
+	w.softReset()
+	w.LineDiscipline = w.defaults.LineDiscipline
+	w.altSavedCursor = nil
+	w.lastPrinted = w.lastPrinted[:0]
+
+	if !w.ClearOnReset {
+		if w.Position.Col > 0 || w.PendingWrap {
+			w.lineFeed()
+		}
+		w.Position.Col = 0
+		return nil
+	}
+
+	top := w.screenTop()
+	for line := top; line <= w.MaxLine; line++ {
+		if err := w.Output.ClearRight(Pos{Line: line}); err != nil {
+			return err
+		}
+	}
+	w.Position = Pos{Line: top}
+	return nil
+}
+
+// WithClearOnReset makes FullReset clear the screen like a terminal does.
+func WithClearOnReset() WriterOption {
+	return func(w *Writer) {
+		w.ClearOnReset = true
+	}
+}
@@ -0,0 +1,179 @@
+package ansi
+
+import "io"
+
+// LineScanner reads ANSI input and yields its lines one at a time, once
+// they're final, like bufio.Scanner. Only the lines that can still change
+// are kept in memory.
+type LineScanner struct {
+	// Writer interprets the input. Its Output mustn't be replaced.
+	Writer *Writer
+	// Window is how many lines above the cursor can still be rewritten by
+	// moving the cursor up, and are held back until the cursor moves further
+	// down. Anything printed above them is dropped.
+	Window int
+
+	r      io.Reader
+	buf    []byte
+	window windowLines
+	ready  Lines
+	line   Line
+	lineNo int
+	empty  int
+	done   bool
+	err    error
+}
+
+func NewLineScanner(r io.Reader, opts ...WriterOption) *LineScanner {
+	s := &LineScanner{
+		r:   r,
+		buf: make([]byte, copyBufferSize),
+	}
+	s.Writer = NewWriter(&s.window, opts...)
+	return s
+}
+
+// Scan advances to the next line, returning false once there are none left
+// or an error happened.
+func (s *LineScanner) Scan() bool {
+	for len(s.ready) == 0 {
+		if s.done {
+			return false
+		}
+		s.read()
+	}
+	s.line, s.ready = s.ready[0], s.ready[1:]
+	s.lineNo++
+	return true
+}
+
+// Line is the line Scan advanced to.
+func (s *LineScanner) Line() Line {
+	return s.line
+}
+
+// LineNumber is the index of Line among all the lines of the input.
+func (s *LineScanner) LineNumber() int {
+	return s.lineNo - 1
+}
+
+// Err is the first error that happened, other than io.EOF.
+func (s *LineScanner) Err() error {
+	return s.err
+}
+
+func (s *LineScanner) read() {
+	n, err := s.r.Read(s.buf)
+	if n == 0 && err == nil {
+		if s.empty++; s.empty >= maxEmptyReads {
+			s.fail(io.ErrNoProgress)
+		}
+		return
+	}
+	s.empty = 0
+	if n > 0 {
+		if _, werr := s.Writer.Write(s.buf[:n]); werr != nil {
+			s.fail(werr)
+			return
+		}
+		window := s.Window
+		if window < 0 {
+			window = 0
+		}
+		s.ready = append(s.ready, s.window.release(s.Writer.mainLine()-window)...)
+	}
+	switch {
+	case err == io.EOF:
+		if cerr := s.Writer.Close(); cerr != nil {
+			s.fail(cerr)
+			return
+		}
+		s.ready = append(s.ready, s.window.release(s.window.end())...)
+		s.done = true
+	case err != nil:
+		s.fail(err)
+	}
+}
+
+func (s *LineScanner) fail(err error) {
+	s.err = err
+	s.done = true
+	s.ready = nil
+}
+
+// windowLines is an Output that only holds the lines from offset onwards.
+type windowLines struct {
+	offset int
+	lines  Lines
+}
+
+func (w *windowLines) Print(data []byte, style Style, pos Pos) error {
+	if pos.Line < w.offset {
+		return nil
+	}
+	pos.Line -= w.offset
+	return w.lines.Print(data, style, pos)
+}
+
+func (w *windowLines) PrintChunk(chunk Chunk, pos Pos) error {
+	if pos.Line < w.offset {
+		return nil
+	}
+	pos.Line -= w.offset
+	return w.lines.PrintChunk(chunk, pos)
+}
+
+func (w *windowLines) ClearRight(pos Pos) error {
+	if pos.Line < w.offset {
+		return nil
+	}
+	pos.Line -= w.offset
+	return w.lines.ClearRight(pos)
+}
+
+func (w *windowLines) MarkWrapped(line int) error {
+	if line < w.offset {
+		return nil
+	}
+	return w.lines.MarkWrapped(line - w.offset)
+}
+
+func (w *windowLines) PrintImage(image *Image, style Style, pos Pos) error {
+	if pos.Line < w.offset {
+		return nil
+	}
+	pos.Line -= w.offset
+	return w.lines.PrintImage(image, style, pos)
+}
+
+// end is the line after the last one held.
+func (w *windowLines) end() int {
+	return w.offset + len(w.lines)
+}
+
+// release lets go of the lines before line, returning them. Lines that were
+// never printed to are empty.
+func (w *windowLines) release(line int) Lines {
+	n := line - w.offset
+	if n <= 0 {
+		return nil
+	}
+	released := make(Lines, n)
+	copy(released, w.lines)
+	for i := range released {
+		if released[i] == nil {
+			released[i] = Line{}
+		}
+	}
+	if n < len(w.lines) {
+		// Don't keep the released lines alive through the backing array
+		for i := 0; i < n; i++ {
+			w.lines[i] = nil
+		}
+		w.lines = w.lines[n:]
+	} else {
+		w.lines = nil
+	}
+	w.offset = line
+	return released
+}
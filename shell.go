@@ -0,0 +1,79 @@
+package ansi
+
+import "strings"
+
+// CommandMarkKind is the part of a shell command a CommandMark starts.
+type CommandMarkKind byte
+
+const (
+	PromptStart     CommandMarkKind = 'A'
+	CommandStart    CommandMarkKind = 'B'
+	CommandExecuted CommandMarkKind = 'C'
+	CommandFinished CommandMarkKind = 'D'
+)
+
+// CommandSection is a command run in a shell with prompt integration,
+// delimited by CommandMarks. Lines are lines of the main screen.
+type CommandSection struct {
+	// PromptLine is where the prompt was printed, CommandLine where the
+	// command was typed in and OutputLine where its output starts.
+	PromptLine  int
+	CommandLine int
+	OutputLine  int
+	// EndLine is the line the cursor was on once the command finished, so
+	// the output ends on it if anything was printed there.
+	EndLine int
+
+	// Command is what was typed in after the prompt.
+	Command string
+
+	Finished    bool
+	ExitCode    int
+	HasExitCode bool
+}
+
+func (w *Writer) commandMark(mark CommandMark) {
+	line := w.mainLine()
+	if mark.Kind == PromptStart || len(w.Commands) == 0 || w.Commands[len(w.Commands)-1].Finished {
+		w.Commands = append(w.Commands, CommandSection{
+			PromptLine:  line,
+			CommandLine: line,
+			OutputLine:  line,
+			EndLine:     line,
+		})
+	}
+	section := &w.Commands[len(w.Commands)-1]
+
+	w.readingCommand = false
+	switch mark.Kind {
+	case CommandStart:
+		section.CommandLine = line
+		section.OutputLine = line
+		section.EndLine = line
+		w.readingCommand = true
+		w.command = w.command[:0]
+	case CommandExecuted:
+		section.Command = strings.TrimSpace(string(w.command))
+		section.OutputLine = line
+		section.EndLine = line
+		w.command = w.command[:0]
+	case CommandFinished:
+		section.EndLine = line
+		section.Finished = true
+		section.ExitCode = mark.ExitCode
+		section.HasExitCode = mark.HasExitCode
+	}
+}
+
+// recordCommand adds data to the command being typed in, if it is, up to
+// maxStringLength bytes so that a prompt that's never ended can't make us
+// allocate arbitrary amounts of memory.
+func (w *Writer) recordCommand(data []byte) {
+	if !w.readingCommand {
+		return
+	}
+	if room := maxStringLength - len(w.command); len(data) > room {
+		data = data[:room]
+	}
+	w.command = append(w.command, data...)
+}
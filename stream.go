@@ -0,0 +1,110 @@
+package ansi
+
+import (
+	"errors"
+	"io"
+)
+
+var (
+	// ErrUnfinished is returned with ReportUnfinished when the input ended
+	// in the middle of an escape sequence or character.
+	ErrUnfinished = errors.New("ansi: input ended with an unfinished escape sequence or character")
+	// ErrClosed is returned when writing to a Writer that was closed.
+	ErrClosed = errors.New("ansi: write to closed Writer")
+)
+
+// UnfinishedPolicy decides what happens to the bytes of an unfinished escape
+// sequence or character once the input ends.
+type UnfinishedPolicy int
+
+const (
+	// DropUnfinished leaves them out.
+	DropUnfinished UnfinishedPolicy = iota
+	// PrintUnfinished prints them as they are.
+	PrintUnfinished
+	// ReportUnfinished leaves them out, and returns ErrUnfinished.
+	ReportUnfinished
+)
+
+// copyBufferSize is how much ReadFrom reads at once.
+const copyBufferSize = 32 * 1024
+
+// WriteString is Write for a string. It implements io.StringWriter.
+func (w *Writer) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// ReadFrom writes everything read from r until EOF, without flushing. It
+// implements io.ReaderFrom.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	buf := make([]byte, copyBufferSize)
+	var total int64
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			written, werr := w.Write(buf[:n])
+			total += int64(written)
+			if werr != nil {
+				return total, werr
+			}
+		}
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// Flush ends the input written so far, dealing with what's left unfinished
+// of it according to Unfinished. Whatever is written next starts afresh.
+// Every Stream is flushed too.
+func (w *Writer) Flush() error {
+	err := w.flush(w.Parser, "")
+	for _, s := range w.streams {
+		if serr := s.Flush(); err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// flush ends the input parsed by parser, from stream.
+func (w *Writer) flush(parser *Parser, stream string) error {
+	rest := parser.Flush()
+	if len(rest) == 0 {
+		return nil
+	}
+	switch w.Unfinished {
+	case PrintUnfinished:
+		defer func(previous string) { w.stream = previous }(w.stream)
+		w.stream = stream
+		return w.Action(Print(rest))
+	case ReportUnfinished:
+		return ErrUnfinished
+	}
+	return nil
+}
+
+// Close flushes the Writer, after which nothing more can be written to it,
+// and commits every line that was printed to.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := w.Flush()
+	if cerr := w.commitLines(w.printedLines); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// WithUnfinishedPolicy decides what Flush and Close do with unfinished
+// escape sequences and characters.
+func WithUnfinishedPolicy(policy UnfinishedPolicy) WriterOption {
+	return func(w *Writer) {
+		w.Unfinished = policy
+	}
+}
@@ -0,0 +1,43 @@
+package ansi
+
+// StreamWriter is one of several named input streams of a Writer, such as a
+// program's stdout and stderr, which share its screen. Each has a Parser of
+// its own, so that an escape sequence or character split across writes to
+// one stream isn't broken by writes to another in between. The text written
+// to it is tagged with its name as Chunk.Stream, by Outputs that implement
+// ChunkOutput.
+type StreamWriter struct {
+	Name   string
+	Parser *Parser
+
+	writer *Writer
+}
+
+// Stream is the input stream called name, which is created the first time.
+func (w *Writer) Stream(name string) *StreamWriter {
+	for _, s := range w.streams {
+		if s.Name == name {
+			return s
+		}
+	}
+	parser := NewParser()
+	parser.Compatibility = w.Parser.Compatibility
+	s := &StreamWriter{Name: name, Parser: parser, writer: w}
+	w.streams = append(w.streams, s)
+	return s
+}
+
+// Write interprets input like Writer.Write. It implements io.Writer.
+func (s *StreamWriter) Write(input []byte) (int, error) {
+	return s.writer.write(s.Parser, s.Name, input)
+}
+
+// WriteString is Write for a string. It implements io.StringWriter.
+func (s *StreamWriter) WriteString(str string) (int, error) {
+	return s.Write([]byte(str))
+}
+
+// Flush ends the input written to the stream so far, like Writer.Flush.
+func (s *StreamWriter) Flush() error {
+	return s.writer.flush(s.Parser, s.Name)
+}
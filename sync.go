@@ -0,0 +1,173 @@
+package ansi
+
+import "sync"
+
+// SyncWriter is a Writer with its own Lines that can be written to by one
+// goroutine while others take snapshots of it.
+//
+// Snapshots share the lines that haven't changed since with the Writer's
+// Lines, which copies a line before changing it, so taking one only costs a
+// copy of the list of lines.
+type SyncWriter struct {
+	mu       sync.Mutex
+	writer   *Writer
+	lines    cowLines
+	version  int
+	snapshot *Snapshot
+}
+
+// Snapshot is the output of a SyncWriter as it was at Version, which is
+// increased by every change. It mustn't be modified, as it's shared with
+// other snapshots.
+type Snapshot struct {
+	Version int
+	Lines   Lines
+	State   State
+}
+
+func NewSyncWriter(opts ...WriterOption) *SyncWriter {
+	s := &SyncWriter{}
+	s.writer = NewWriter(&s.lines, opts...)
+	return s
+}
+
+// Write is Writer.Write.
+func (s *SyncWriter) Write(input []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+	return s.writer.Write(input)
+}
+
+// WriteString is Writer.WriteString.
+func (s *SyncWriter) WriteString(str string) (int, error) {
+	return s.Write([]byte(str))
+}
+
+// Flush is Writer.Flush.
+func (s *SyncWriter) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+	return s.writer.Flush()
+}
+
+// Close is Writer.Close.
+func (s *SyncWriter) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+	return s.writer.Close()
+}
+
+// Resize is Writer.Resize.
+func (s *SyncWriter) Resize(lines, cols int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+	return s.writer.Resize(lines, cols)
+}
+
+// Stream is Writer.Stream, which can be written to while other streams are.
+func (s *SyncWriter) Stream(name string) *SyncStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &SyncStream{sync: s, stream: s.writer.Stream(name)}
+}
+
+// SyncStream is a StreamWriter of a SyncWriter.
+type SyncStream struct {
+	sync   *SyncWriter
+	stream *StreamWriter
+}
+
+// Write is StreamWriter.Write.
+func (s *SyncStream) Write(input []byte) (int, error) {
+	s.sync.mu.Lock()
+	defer s.sync.mu.Unlock()
+	s.sync.version++
+	return s.stream.Write(input)
+}
+
+// Snapshot is the output as it is now, which later writes leave untouched.
+func (s *SyncWriter) Snapshot() *Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.snapshot == nil || s.snapshot.Version != s.version {
+		s.snapshot = &Snapshot{
+			Version: s.version,
+			Lines:   s.lines.share(),
+			State:   s.writer.State,
+		}
+	}
+	return s.snapshot
+}
+
+// cowLines is Lines that copies lines shared with snapshots before changing
+// them.
+type cowLines struct {
+	lines Lines
+	owned []bool
+}
+
+func (c *cowLines) Print(data []byte, style Style, pos Pos) error {
+	c.own(pos.Line)
+	return c.lines.Print(data, style, pos)
+}
+
+func (c *cowLines) PrintChunk(chunk Chunk, pos Pos) error {
+	c.own(pos.Line)
+	return c.lines.PrintChunk(chunk, pos)
+}
+
+func (c *cowLines) ClearRight(pos Pos) error {
+	c.own(pos.Line)
+	return c.lines.ClearRight(pos)
+}
+
+func (c *cowLines) MarkWrapped(line int) error {
+	c.own(line)
+	return c.lines.MarkWrapped(line)
+}
+
+func (c *cowLines) PrintImage(image *Image, style Style, pos Pos) error {
+	c.own(pos.Line)
+	return c.lines.PrintImage(image, style, pos)
+}
+
+// Reflow rewraps the lines, which may end up sharing data with the ones
+// they were rewrapped from, so none of them are owned afterwards.
+func (c *cowLines) Reflow(cols int, positions ...Pos) ([]Pos, error) {
+	newPositions, err := c.lines.Reflow(cols, positions...)
+	c.owned = make([]bool, len(c.lines))
+	return newPositions, err
+}
+
+// own makes sure line isn't shared with any snapshot.
+func (c *cowLines) own(line int) {
+	if line < 0 {
+		line = 0
+	}
+	if line >= len(c.lines) {
+		// It doesn't exist yet, so the Lines will make it from scratch
+		return
+	}
+	for len(c.owned) < len(c.lines) {
+		c.owned = append(c.owned, true)
+	}
+	if !c.owned[line] {
+		c.lines[line] = copyLine(c.lines[line])
+		c.owned[line] = true
+	}
+}
+
+// share copies the list of lines, which are shared from then on.
+func (c *cowLines) share() Lines {
+	shared := make(Lines, len(c.lines))
+	copy(shared, c.lines)
+	c.owned = c.owned[:0]
+	for range c.lines {
+		c.owned = append(c.owned, false)
+	}
+	return shared
+}
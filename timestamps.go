@@ -0,0 +1,48 @@
+package ansi
+
+import "time"
+
+// timestamp is when text printed now is stamped with, or nil without
+// timestamps. Text printed at the same time shares the same one.
+func (w *Writer) timestamp() *time.Time {
+	if w.Clock == nil {
+		return nil
+	}
+	t := w.Clock()
+	if w.TimestampPrecision > 0 {
+		t = t.Truncate(w.TimestampPrecision)
+	}
+	t = t.Round(0).UTC()
+	if w.lastTimestamp == nil || !w.lastTimestamp.Equal(t) {
+		w.lastTimestamp = &t
+	}
+	return w.lastTimestamp
+}
+
+// LineTime is when line was first printed to, as far as the timestamps of
+// what's left of it tell, or zero without timestamps.
+func LineTime(line Line) time.Time {
+	var first time.Time
+	for _, chunk := range line {
+		t := chunk.Time
+		if t != nil && (first.IsZero() || t.Before(first)) {
+			first = *t
+		}
+	}
+	return first
+}
+
+// WithTimestamps stamps printed text with the time given by clock, or
+// time.Now if it's nil, in Chunk.Time, for Outputs that implement
+// ChunkOutput. Timestamps are truncated to
+// precision, if it's > 0, so that text printed around the same time can
+// share a chunk rather than taking up one per write.
+func WithTimestamps(clock func() time.Time, precision time.Duration) WriterOption {
+	return func(w *Writer) {
+		if clock == nil {
+			clock = time.Now
+		}
+		w.Clock = clock
+		w.TimestampPrecision = precision
+	}
+}
@@ -0,0 +1,187 @@
+package ansi
+
+import (
+	"encoding/binary"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	zeroWidthJoiner = '\u200d'
+
+	minRegionalIndicator = '\U0001F1E6'
+	maxRegionalIndicator = '\U0001F1FF'
+	minEmojiModifier     = '\U0001F3FB'
+	maxEmojiModifier     = '\U0001F3FF'
+)
+
+// runeWidth is the number of terminal cells r takes up on its own.
+func runeWidth(r rune) int {
+	switch {
+	case r < 0x7f:
+		// Control characters are kept in the text as-is, so they take up
+		// a cell like they always have
+		return 1
+	case isZeroWidth(r):
+		return 0
+	case unicode.Is(wideTable, r):
+		return 2
+	}
+	return 1
+}
+
+func isZeroWidth(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) ||
+		// Hangul Jungseong and Jongseong combine with the preceding Choseong
+		(r >= 0x1160 && r <= 0x11ff)
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= minRegionalIndicator && r <= maxRegionalIndicator
+}
+
+func isEmojiModifier(r rune) bool {
+	return r >= minEmojiModifier && r <= maxEmojiModifier
+}
+
+// nextCluster returns the size in bytes and the width in cells of the
+// grapheme cluster data starts with.
+//
+// This is a close approximation of UAX #29 that covers what terminals
+// render as a single glyph: combining marks, variation selectors, emoji
+// modifiers, ZWJ sequences and regional indicator pairs (flags).
+func nextCluster(data []byte) (size, width int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	if data[0] < utf8.RuneSelf && (len(data) == 1 || data[1] < utf8.RuneSelf) {
+		return 1, 1
+	}
+
+	r, size := utf8.DecodeRune(data)
+	width = runeWidth(r)
+	prev := r
+	regionalPair := false
+	for size < len(data) {
+		next, nextSize := utf8.DecodeRune(data[size:])
+		switch {
+		case prev == zeroWidthJoiner,
+			isZeroWidth(next),
+			isEmojiModifier(next):
+		case isRegionalIndicator(prev) && isRegionalIndicator(next) && !regionalPair:
+			// Flags are rendered as a single wide glyph
+			regionalPair = true
+			width = 2
+		default:
+			return size, width
+		}
+		size += nextSize
+		prev = next
+	}
+	return size, width
+}
+
+// lastCluster returns the last grapheme cluster of data.
+func lastCluster(data []byte) []byte {
+	if n := len(data); n > 0 && data[n-1] < utf8.RuneSelf {
+		return data[n-1:]
+	}
+	// Only the trailing non-ASCII run, and the character right before it
+	// which might be what it combines with, need to be looked at
+	from := len(data)
+	for from > 0 && data[from-1] >= utf8.RuneSelf {
+		from--
+	}
+	if from > 0 {
+		from--
+	}
+	data = data[from:]
+
+	start := 0
+	for i := 0; i < len(data); {
+		size, _ := nextCluster(data[i:])
+		start = i
+		i += size
+	}
+	return data[start:]
+}
+
+// textWidth is the number of cells data takes up.
+func textWidth(data []byte) int {
+	i := 0
+	// Check 8 bytes at a time for anything that isn't ASCII
+	for ; i+8 <= len(data); i += 8 {
+		if binary.LittleEndian.Uint64(data[i:])&0x8080808080808080 != 0 {
+			break
+		}
+	}
+	for ; i < len(data); i++ {
+		if data[i] >= utf8.RuneSelf {
+			return i + unicodeWidth(data[i:])
+		}
+	}
+	return len(data)
+}
+
+func unicodeWidth(data []byte) int {
+	width := 0
+	for i := 0; i < len(data); {
+		size, w := nextCluster(data[i:])
+		width += w
+		i += size
+	}
+	return width
+}
+
+// splitWidth splits data after as many grapheme clusters as fit within width
+// cells.
+func splitWidth(data []byte, width int) (fits, rest []byte) {
+	n, _, _, _ := splitCells(data, width)
+	return data[:n], data[n:]
+}
+
+// splitCells finds where data needs to be split so that everything before
+// takes up col cells. If a wide cluster straddles col, before ends at the
+// start of it and after begins past its end; padBefore and padAfter are the
+// number of cells it leaves uncovered on either side.
+func splitCells(data []byte, col int) (before, after, padBefore, padAfter int) {
+	if col < 0 {
+		col = 0
+	}
+	if col >= len(data) && isASCII(data) {
+		return len(data), len(data), 0, 0
+	}
+	if col < len(data) && isASCII(data[:col+1]) {
+		return col, col, 0, 0
+	}
+
+	cells := 0
+	for i := 0; i < len(data); {
+		if cells >= col && (data[i] < utf8.RuneSelf || !startsZeroWidth(data[i:])) {
+			return i, i, 0, 0
+		}
+		size, w := nextCluster(data[i:])
+		if cells+w > col {
+			return i, i + size, col - cells, cells + w - col
+		}
+		cells += w
+		i += size
+	}
+	return len(data), len(data), 0, 0
+}
+
+// startsZeroWidth is true when data starts with a cluster that takes no space,
+// which belongs with whatever precedes it.
+func startsZeroWidth(data []byte) bool {
+	_, w := nextCluster(data)
+	return w == 0
+}
+
+func isASCII(data []byte) bool {
+	for _, b := range data {
+		if b >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
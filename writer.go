@@ -1,8 +1,18 @@
 package ansi
 
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
 const (
 	defaultLines = 48
 	defaultCols  = 80
+
+	// Caps Repeat, so that a single sequence can't make us allocate
+	// arbitrary amounts of memory
+	maxRepeat = 1 << 16
 )
 
 type LineDiscipline int
@@ -16,16 +26,121 @@ type State struct {
 	Style          Style
 	LineDiscipline LineDiscipline
 	Position       Pos
-	SavedPosition  *Pos
+	Charsets       Charsets
+	SavedCursor    *SavedCursor
+	// SavedPosition is the position of SavedCursor.
+	//
+	// Deprecated: use SavedCursor, which has the rest of what was saved.
+	// Setting SavedPosition only has an effect while SavedCursor is nil.
+	SavedPosition *Pos
 
 	MaxLine int
 	MaxCol  int
+	// Height is the number of lines of the screen, which unlike MaxLine
+	// doesn't grow along with the output.
+	Height int
+
+	// FixedWidth stops prints from growing MaxCol. Text reaching the last
+	// column is wrapped onto the next line instead, as long as Autowrap is on.
+	FixedWidth bool
+	// Autowrap is DECAWM ("\x1b[?7h"), only meaningful with FixedWidth.
+	Autowrap bool
+	// PendingWrap is set once the last column has been printed to. Like
+	// xterm, the cursor stays there until the next print wraps it.
+	PendingWrap bool
+
+	// AltScreen is set while output goes to the alternate screen.
+	AltScreen bool
+
+	// WindowTitle, IconName and WorkingDirectory are the last ones reported
+	// through OSC 0, 1, 2 and 7.
+	WindowTitle      string
+	IconName         string
+	WorkingDirectory string
+	// TitleHistory has every change of WindowTitle, in order.
+	TitleHistory []TitleChange
+}
+
+// TitleChange is the window title being set to Title while the cursor was on
+// Line of the main screen.
+type TitleChange struct {
+	Line  int
+	Title string
+}
+
+// SavedCursor is what gets saved by SaveCursorPosition (DECSC) and brought
+// back by RestoreCursorPosition (DECRC).
+type SavedCursor struct {
+	Position    Pos
+	Style       Style
+	Charsets    Charsets
+	PendingWrap bool
 }
 
 type Writer struct {
 	State
 	Parser *Parser
 	Output Output
+
+	// Reflow rewraps soft-wrapped lines when the width changes on Resize,
+	// if Output implements ReflowOutput.
+	Reflow bool
+	// BackgroundColorErase (BCE) fills erased cells with the current
+	// background colour, like xterm, instead of leaving them blank.
+	BackgroundColorErase bool
+
+	// Responses receives the answers to queries found in the input, see
+	// WithResponses. Queries are ignored when it's nil.
+	Responses io.Writer
+	// Palette and DefaultBackground are what colour queries are answered
+	// with, the default foreground being the palette's DefaultColor.
+	Palette           ColorPalette
+	DefaultBackground Color
+
+	AltScreenPolicy AltScreenPolicy
+	// AltScreenSnapshots holds the final frame of each use of the alternate
+	// screen, with SnapshotAltScreen.
+	AltScreenSnapshots []Lines
+
+	// Commands are the sections delimited by shell integration marks.
+	Commands []CommandSection
+
+	// OnNotification is called on every bell and desktop notification.
+	OnNotification func(Notification)
+
+	GroupDetector GroupDetector
+	// Groups are the top-level groups found by GroupDetector.
+	Groups []*Group
+
+	// ClearOnReset makes FullReset clear the screen, rather than carry on
+	// below what was output before.
+	ClearOnReset bool
+	// Unfinished decides what Flush and Close do with what's left of the
+	// input when it ends in the middle of an escape sequence or character.
+	Unfinished UnfinishedPolicy
+
+	// Clock stamps printed text with the time, see WithTimestamps.
+	Clock func() time.Time
+	// TimestampPrecision is what timestamps are truncated to.
+	TimestampPrecision time.Duration
+
+	defaults       State
+	closed         bool
+	main           *mainScreen
+	alt            Lines
+	altSavedCursor *SavedCursor
+	lastPrinted    []byte
+	readingCommand bool
+	command        []byte
+	groupLine      groupLine
+	openGroups     []*Group
+	committed      int
+	printedLines   int
+	stream         string
+	streams        []*StreamWriter
+	lastTimestamp  *time.Time
+	// reached is the furthest line linefeeds took the cursor to
+	reached int
 }
 
 func NewWriter(output Output, opts ...WriterOption) *Writer {
@@ -33,44 +148,71 @@ func NewWriter(output Output, opts ...WriterOption) *Writer {
 		State: State{
 			MaxLine: defaultLines,
 			MaxCol:  defaultCols,
+			Height:  defaultLines,
 
 			LineDiscipline: Cooked,
+			Autowrap:       true,
 		},
-		Parser: NewParser(),
-		Output: output,
+		Parser:            NewParser(),
+		Output:            output,
+		Palette:           XTermPalette,
+		DefaultBackground: Black,
 	}
 	for _, opt := range opts {
 		opt(w)
 	}
+	w.defaults = w.State
 	return w
 }
 
-func (w *Writer) Write(input []byte) (int64, error) {
+// Write interprets input, which may end in the middle of an escape sequence
+// or character that the next Write finishes. It implements io.Writer.
+func (w *Writer) Write(input []byte) (int, error) {
+	return w.write(w.Parser, "", input)
+}
+
+// write interprets input from stream, parsed by parser.
+func (w *Writer) write(parser *Parser, stream string, input []byte) (int, error) {
+	if w.closed {
+		return 0, ErrClosed
+	}
+	defer func(previous string) { w.stream = previous }(w.stream)
+	w.stream = stream
+
 	n := len(input)
 	for {
-		action, newInput := w.Parser.Parse(input)
+		action, newInput := parser.Parse(input)
 		if action == nil {
 			break
 		}
 		if err := w.Action(action); err != nil {
-			return int64(n - len(input)), err
+			return n - len(input), err
 		}
 		input = newInput
 	}
-	return int64(n), nil
+	return n, nil
 }
 
 func (w *Writer) Action(act Action) error {
+	if err := w.action(act); err != nil {
+		return err
+	}
+	if w.AltScreen {
+		return nil
+	}
+	return w.commitLines(w.screenTop())
+}
+
+func (w *Writer) action(act Action) error {
 	switch v := act.(type) {
 	case Print:
-		if err := w.Output.Print(v, w.Style, w.Position); err != nil {
+		data := w.Charsets.active().translate(v)
+		w.trackGroupLine(data)
+		if err := w.print(data); err != nil {
 			return err
 		}
-		endCol := w.Position.Col + len(v)
-		if endCol > w.MaxCol {
-			w.MaxCol = endCol
-		}
-		w.Position.Col = endCol
+		w.lastPrinted = append(w.lastPrinted[:0], lastCluster(data)...)
+		w.recordCommand(data)
 	case Reset:
 		w.Style = Style{}
 	case SetForeground:
@@ -105,27 +247,54 @@ func (w *Writer) Action(act Action) error {
 		w.moveCursor(0, -int(v))
 	case CursorColumn:
 		w.moveCursorTo(w.Position.Line, int(v))
+	case CursorLine:
+		w.moveCursorTo(int(v), w.Position.Col)
+	case Repeat:
+		w.groupLine.valid = false
+		return w.repeat(int(v))
 	case Linebreak:
-		switch w.LineDiscipline {
-		case Raw:
-			w.Position.Line++
-		case Cooked:
-			w.Position.Line++
-			w.Position.Col = 0
+		if stripped, err := w.endGroupLine(); stripped || err != nil {
+			return err
 		}
-		if w.Position.Line > w.MaxLine {
-			w.MaxLine = w.Position.Line
+		w.recordCommand([]byte{'\n'})
+		if w.LineDiscipline == Cooked {
+			w.Position.Col = 0
 		}
+		w.lineFeed()
 	case CarriageReturn:
 		w.Position.Col = 0
+		w.PendingWrap = false
 	case SaveCursorPosition:
-		pos := w.Position
-		w.SavedPosition = &pos
+		w.setSavedCursor(&SavedCursor{
+			Position:    w.Position,
+			Style:       w.Style,
+			Charsets:    w.Charsets,
+			PendingWrap: w.PendingWrap,
+		})
 	case RestoreCursorPosition:
-		if w.SavedPosition != nil {
-			w.Position = *w.SavedPosition
+		if w.SavedCursor == nil && w.SavedPosition != nil {
+			w.moveCursorTo(w.SavedPosition.Line, w.SavedPosition.Col)
+		}
+		if saved := w.SavedCursor; saved != nil {
+			// The screen may have been resized since
+			w.moveCursorTo(saved.Position.Line, saved.Position.Col)
+			w.Style = saved.Style
+			w.Charsets = saved.Charsets
+			w.PendingWrap = saved.PendingWrap && w.Position == saved.Position
 		}
+	case SetCharset:
+		if v.G >= 0 && v.G < len(w.Charsets.G) {
+			w.Charsets.G[v.G] = v.Charset
+		}
+	case ShiftOut:
+		w.Charsets.ShiftOut = true
+	case ShiftIn:
+		w.Charsets.ShiftOut = false
+	case SetAutowrap:
+		w.Autowrap = bool(v)
+		w.PendingWrap = w.PendingWrap && w.Autowrap
 	case EraseLine:
+		w.PendingWrap = false
 		startOfLine := w.Position
 		startOfLine.Col = 0
 		switch EraseMode(v) {
@@ -133,32 +302,260 @@ func (w *Writer) Action(act Action) error {
 			if w.Position.Col == 0 {
 				return nil
 			}
-			empty := spacer(w.Position.Col)
-			w.Output.Print(empty, Style{}, startOfLine)
+			empty := Chunk{Data: spacer(w.Position.Col), Style: w.eraseStyle()}
+			return w.outputChunk(empty, startOfLine)
 		case EraseToEnd:
 			pos := w.Position
 			pos.Col++
-			if err := w.Output.ClearRight(pos); err != nil {
+			return w.clearRight(pos)
+		case EraseAll:
+			return w.clearRight(startOfLine)
+		}
+
+	case EraseDisplay:
+		return w.eraseDisplay(EraseMode(v))
+	case SetAlternateScreen:
+		if v.Enabled {
+			w.enterAltScreen(v.SaveCursor)
+		} else {
+			return w.exitAltScreen(v.SaveCursor, w.AltScreenPolicy)
+		}
+	case SetWindowTitle:
+		if string(v) != w.WindowTitle {
+			w.WindowTitle = string(v)
+			w.TitleHistory = append(w.TitleHistory, TitleChange{
+				Line:  w.mainLine(),
+				Title: w.WindowTitle,
+			})
+		}
+	case SetIconName:
+		w.IconName = string(v)
+	case SetWorkingDirectory:
+		w.WorkingDirectory = string(v)
+	case CommandMark:
+		w.commandMark(v)
+	case FullReset:
+		return w.fullReset()
+	case SoftReset:
+		w.softReset()
+	case Bell:
+		w.notify(Notification{Bell: true})
+	case Notify:
+		w.notify(Notification{Title: v.Title, Body: v.Body})
+	case InlineImage:
+		w.groupLine.valid = false
+		return w.printImage(Image(v))
+	case OperatingSystemCommand:
+		w.groupOSC(v)
+	case ReportStatus, ReportCursorPosition, ReportDeviceAttributes,
+		ReportForeground, ReportBackground, ReportPaletteColor:
+		return w.report(act)
+	}
+
+	return nil
+}
+
+func (w *Writer) print(data []byte) error {
+	defer func() { w.touch(w.Position.Line) }()
+	style := w.Style
+	if !w.FixedWidth {
+		if err := w.outputPrint(data, style, w.Position); err != nil {
+			return err
+		}
+		endCol := w.Position.Col + textWidth(data)
+		if endCol > w.MaxCol {
+			w.MaxCol = endCol
+		}
+		w.Position.Col = endCol
+		return nil
+	}
+
+	for len(data) > 0 {
+		if w.PendingWrap && startsZeroWidth(data) {
+			// Combining characters still belong to the last column
+			size, _ := nextCluster(data)
+			end := Pos{Line: w.Position.Line, Col: w.MaxCol}
+			if err := w.outputPrint(data[:size], style, end); err != nil {
 				return err
 			}
-		case EraseAll:
-			if err := w.Output.ClearRight(startOfLine); err != nil {
+			data = data[size:]
+			continue
+		}
+		if w.PendingWrap {
+			if err := w.wrapLine(); err != nil {
 				return err
 			}
 		}
+		if w.Position.Col >= w.MaxCol {
+			w.Position.Col = w.MaxCol - 1
+		}
 
-	case EraseDisplay:
-		// unsupported
+		fits, rest := splitWidth(data, w.MaxCol-w.Position.Col)
+		if len(fits) == 0 && w.Position.Col == 0 {
+			// Too wide for the screen altogether, print it anyway so that
+			// it doesn't get lost.
+			size, _ := nextCluster(data)
+			fits, rest = data[:size], data[size:]
+		}
+		if len(fits) > 0 {
+			if err := w.outputPrint(fits, style, w.Position); err != nil {
+				return err
+			}
+			w.Position.Col += textWidth(fits)
+		}
+		if w.Position.Col >= w.MaxCol || len(rest) > 0 {
+			w.Position.Col = w.MaxCol - 1
+			w.PendingWrap = w.Autowrap
+		}
+
+		if len(rest) > 0 && !w.Autowrap {
+			// Without autowrap, every character past the end overwrites the
+			// last column, so only the final one is left to see.
+			return w.outputPrint(lastCluster(rest), style, w.Position)
+		}
+		data = rest
+	}
+	return nil
+}
+
+// repeat prints the last printed character n more times (REP).
+func (w *Writer) repeat(n int) error {
+	if len(w.lastPrinted) == 0 || n <= 0 {
+		return nil
+	}
+	if n > maxRepeat {
+		n = maxRepeat
+	}
+	return w.print(bytes.Repeat(w.lastPrinted, n))
+}
+
+// wrapLine moves the cursor to the start of the next line, marking the
+// current one as soft-wrapped.
+func (w *Writer) wrapLine() error {
+	if wo, ok := w.Output.(WrapOutput); ok {
+		if err := wo.MarkWrapped(w.Position.Line); err != nil {
+			return err
+		}
+	}
+	w.Position.Col = 0
+	w.lineFeed()
+	return nil
+}
+
+func (w *Writer) lineFeed() {
+	w.Position.Line++
+	if w.Position.Line > w.MaxLine {
+		w.MaxLine = w.Position.Line
+	}
+	if w.Position.Line > w.reached {
+		w.reached = w.Position.Line
+	}
+	w.PendingWrap = false
+}
+
+// lastCol is the rightmost column the cursor can be moved to.
+func (w *Writer) lastCol() int {
+	if w.FixedWidth {
+		return w.MaxCol - 1
+	}
+	return w.MaxCol
+}
+
+// Resize changes the screen size mid-stream, like a terminal receiving
+// SIGWINCH. The cursor stays on the line it is on, as lines above it scroll
+// away rather than the cursor moving up. A value <= 0 leaves that dimension
+// unchanged.
+//
+// While the alternate screen is active, the main screen is resized along
+// with it.
+func (w *Writer) Resize(lines, cols int) error {
+	if w.AltScreen {
+		height, maxCol := w.Height, w.MaxCol
+		w.swapMain()
+		err := w.resize(lines, cols)
+		w.swapMain()
+		if err != nil {
+			return err
+		}
+		w.Height, w.MaxCol = height, maxCol
+	}
+	return w.resize(lines, cols)
+}
+
+// resize is Resize for the screen in use.
+func (w *Writer) resize(lines, cols int) error {
+	pos := w.Position
+	if w.PendingWrap {
+		pos.Col++
 	}
+	pending := w.PendingWrap
 
+	if cols > 0 && cols != w.MaxCol {
+		if ro, ok := w.Output.(ReflowOutput); ok && w.Reflow && w.FixedWidth {
+			// The saved cursor, the first uncommitted line and the end of
+			// what's been printed are renumbered like the cursor
+			positions := []Pos{pos}
+			if w.SavedCursor != nil {
+				positions = append(positions, w.SavedCursor.Position)
+			}
+			if !w.AltScreen {
+				positions = append(positions, Pos{Line: w.committed}, Pos{Line: w.printedLines})
+			}
+			newPositions, err := ro.Reflow(cols, positions...)
+			if err != nil {
+				return err
+			}
+			newPos := newPositions[0]
+			newPositions = newPositions[1:]
+			if w.SavedCursor != nil {
+				saved := *w.SavedCursor
+				saved.Position = newPositions[0]
+				w.setSavedCursor(&saved)
+				newPositions = newPositions[1:]
+			}
+			if !w.AltScreen {
+				w.committed = newPositions[0].Line
+				if newPositions[0].Col > 0 {
+					// It was joined onto the end of a committed line, which
+					// isn't committed again
+					w.committed++
+				}
+				w.printedLines = newPositions[1].Line
+				if newPositions[1].Col > 0 {
+					w.printedLines++
+				}
+			}
+			w.MaxLine += newPos.Line - pos.Line
+			w.reached += newPos.Line - pos.Line
+			pending = newPos.Col >= cols
+			pos = newPos
+		}
+		w.MaxCol = cols
+	}
+	if lines > 0 {
+		// The bottom of the screen moves along with its height, but lines
+		// that were already output stay within reach
+		w.MaxLine += lines - w.Height
+		if w.MaxLine < w.reached {
+			w.MaxLine = w.reached
+		}
+		w.Height = lines
+	}
+	if pos.Line > w.MaxLine {
+		w.MaxLine = pos.Line
+	}
+
+	w.moveCursorTo(pos.Line, pos.Col)
+	w.PendingWrap = pending && w.Autowrap && pos.Col > w.lastCol()
 	return nil
 }
 
 func (w *Writer) moveCursorTo(l, c int) {
+	w.PendingWrap = false
 	w.Position.Line = l
 	w.Position.Col = c
-	if w.Position.Line < 0 {
-		w.Position.Line = 0
+	if w.Position.Line < w.firstLine() {
+		w.Position.Line = w.firstLine()
 	}
 	if w.Position.Col < 0 {
 		w.Position.Col = 0
@@ -166,11 +563,89 @@ func (w *Writer) moveCursorTo(l, c int) {
 	if w.Position.Line > w.MaxLine {
 		w.Position.Line = w.MaxLine
 	}
-	if w.Position.Col > w.MaxCol {
-		w.Position.Col = w.MaxCol
+	if w.Position.Col > w.lastCol() {
+		w.Position.Col = w.lastCol()
 	}
 }
 
+// Notification is a bell, or a desktop notification with a title and body,
+// which happened while the cursor was on Line of the main screen.
+type Notification struct {
+	Line  int
+	Bell  bool
+	Title string
+	Body  string
+}
+
+func (w *Writer) notify(n Notification) {
+	if w.OnNotification == nil {
+		return
+	}
+	n.Line = w.mainLine()
+	w.OnNotification(n)
+}
+
+// setSavedCursor sets SavedCursor, and SavedPosition along with it.
+func (w *Writer) setSavedCursor(saved *SavedCursor) {
+	w.SavedCursor = saved
+	w.SavedPosition = nil
+	if saved != nil {
+		pos := saved.Position
+		w.SavedPosition = &pos
+	}
+}
+
+// outputPrint prints data like Output.Print, tagged with the stream it came
+// from and when if Output keeps them.
+func (w *Writer) outputPrint(data []byte, style Style, pos Pos) error {
+	return w.outputChunk(w.tagged(Chunk{Data: data, Style: style}), pos)
+}
+
+// outputChunk prints chunk with PrintChunk if Output implements ChunkOutput,
+// and with Print or PrintImage otherwise, leaving out what they can't take.
+// Images are dropped by Outputs that can't take them.
+func (w *Writer) outputChunk(chunk Chunk, pos Pos) error {
+	if out, ok := w.Output.(ChunkOutput); ok {
+		return out.PrintChunk(chunk, pos)
+	}
+	if chunk.Image != nil {
+		if out, ok := w.Output.(ImageOutput); ok {
+			return out.PrintImage(chunk.Image, chunk.Style, pos)
+		}
+		return nil
+	}
+	return w.Output.Print(chunk.Data, chunk.Style, pos)
+}
+
+// tagged is chunk along with the stream it came from and when.
+func (w *Writer) tagged(chunk Chunk) Chunk {
+	chunk.Stream = w.stream
+	chunk.Time = w.timestamp()
+	return chunk
+}
+
+// eraseStyle is what erased cells are filled with: just the current
+// background colour with BackgroundColorErase, the default style otherwise.
+func (w *Writer) eraseStyle() Style {
+	if !w.BackgroundColorErase {
+		return Style{}
+	}
+	return Style{Background: w.Style.Background}
+}
+
+// clearRight erases the line from pos to the last column. Cells erased with
+// the default style are dropped rather than filled with spaces.
+func (w *Writer) clearRight(pos Pos) error {
+	if err := w.Output.ClearRight(pos); err != nil {
+		return err
+	}
+	style := w.eraseStyle()
+	if style == (Style{}) || pos.Col >= w.MaxCol {
+		return nil
+	}
+	return w.outputChunk(Chunk{Data: spacer(w.MaxCol - pos.Col), Style: style}, pos)
+}
+
 func (w *Writer) moveCursor(dl, dc int) {
 	w.moveCursorTo(w.Position.Line+dl, w.Position.Col+dc)
 }
@@ -183,10 +658,45 @@ func WithLineDiscipline(d LineDiscipline) WriterOption {
 	}
 }
 
+// WithFixedWidth makes the screen cols wide, wrapping printed text onto the
+// next line at the last column instead of growing the screen width.
+func WithFixedWidth(cols int) WriterOption {
+	return func(w *Writer) {
+		if cols > 0 {
+			w.State.MaxCol = cols
+		}
+		w.State.FixedWidth = true
+	}
+}
+
+// WithReflow rewraps soft-wrapped lines to the new width on Resize.
+func WithReflow() WriterOption {
+	return func(w *Writer) {
+		w.Reflow = true
+	}
+}
+
+// WithNotificationHandler calls handler on every bell and desktop
+// notification.
+func WithNotificationHandler(handler func(Notification)) WriterOption {
+	return func(w *Writer) {
+		w.OnNotification = handler
+	}
+}
+
+// WithBackgroundColorErase fills erased cells with the current background
+// colour, the way xterm does.
+func WithBackgroundColorErase() WriterOption {
+	return func(w *Writer) {
+		w.BackgroundColorErase = true
+	}
+}
+
 func WithInitialScreenSize(lines, cols int) WriterOption {
 	return func(w *Writer) {
 		if lines > 0 {
 			w.State.MaxLine = lines
+			w.State.Height = lines
 		}
 		if cols > 0 {
 			w.State.MaxCol = cols
@@ -2,7 +2,15 @@ package ansi_test
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/cowdude/ansi"
 	. "github.com/onsi/gomega"
@@ -21,6 +29,7 @@ type clearCall struct {
 type spyOutput struct {
 	printCalls []printCall
 	clearCalls []clearCall
+	wrapCalls  []int
 }
 
 func (p *spyOutput) Print(data []byte, style ansi.Style, pos ansi.Pos) error {
@@ -39,13 +48,20 @@ func (p *spyOutput) ClearRight(pos ansi.Pos) error {
 	return nil
 }
 
+func (p *spyOutput) MarkWrapped(line int) error {
+	p.wrapCalls = append(p.wrapCalls, line)
+	return nil
+}
+
 func TestWriter_Action(t *testing.T) {
 	for _, tt := range []struct {
 		description    string
 		lineDiscipline ansi.LineDiscipline
+		opts           []ansi.WriterOption
 		actions        []ansi.Action
 		printCalls     []printCall
 		clearCalls     []clearCall
+		wrapCalls      []int
 	}{
 		{
 			description: "makes print calls",
@@ -174,6 +190,57 @@ func TestWriter_Action(t *testing.T) {
 				},
 			},
 		},
+		{
+			description: "can move cursor to a line",
+			actions: []ansi.Action{
+				ansi.CursorColumn(3),
+				ansi.CursorLine(5),
+				ansi.Print("(5,3)"),
+				ansi.CursorLine(-5),
+				ansi.Print("(0,8)"),
+				ansi.CursorLine(1000),
+				ansi.Print("(48,13)"),
+			},
+			printCalls: []printCall{
+				{
+					data: []byte("(5,3)"),
+					pos:  ansi.Pos{Line: 5, Col: 3},
+				},
+				{
+					data: []byte("(0,8)"),
+					pos:  ansi.Pos{Line: 0, Col: 8},
+				},
+				{
+					data: []byte("(48,13)"),
+					pos:  ansi.Pos{Line: 48, Col: 13},
+				},
+			},
+		},
+		{
+			description: "repeats the last printed character",
+			actions: []ansi.Action{
+				ansi.Repeat(3),
+				ansi.Print("ab日"),
+				ansi.Repeat(2),
+				ansi.SetBold(true),
+				ansi.Repeat(1),
+			},
+			printCalls: []printCall{
+				{
+					data: []byte("ab日"),
+					pos:  ansi.Pos{Line: 0, Col: 0},
+				},
+				{
+					data: []byte("日日"),
+					pos:  ansi.Pos{Line: 0, Col: 4},
+				},
+				{
+					data:  []byte("日"),
+					pos:   ansi.Pos{Line: 0, Col: 8},
+					style: ansi.Style{Modifier: ansi.Bold},
+				},
+			},
+		},
 		{
 			description: "can't move cursor beyond current size",
 			actions: []ansi.Action{
@@ -341,6 +408,33 @@ func TestWriter_Action(t *testing.T) {
 				},
 			},
 		},
+		{
+			description: "restoring the cursor brings back its style and charsets",
+			actions: []ansi.Action{
+				ansi.SetForeground(ansi.Red),
+				ansi.SetCharset{G: 1, Charset: ansi.CharsetDECSpecialGraphics},
+				ansi.ShiftOut{},
+				ansi.CursorPosition{Line: 1, Col: 2},
+				ansi.SaveCursorPosition{},
+				ansi.Reset{},
+				ansi.ShiftIn{},
+				ansi.CursorPosition{Line: 0, Col: 0},
+				ansi.Print("q"),
+				ansi.RestoreCursorPosition{},
+				ansi.Print("q"),
+			},
+			printCalls: []printCall{
+				{
+					data: []byte("q"),
+					pos:  ansi.Pos{Line: 0, Col: 0},
+				},
+				{
+					data:  []byte("─"),
+					style: ansi.Style{Foreground: ansi.Red},
+					pos:   ansi.Pos{Line: 1, Col: 2},
+				},
+			},
+		},
 		{
 			description: "erasing lines",
 			actions: []ansi.Action{
@@ -373,11 +467,227 @@ func TestWriter_Action(t *testing.T) {
 				},
 			},
 		},
+		{
+			description: "erasing lines with background colour erase",
+			opts: []ansi.WriterOption{
+				ansi.WithFixedWidth(12),
+				ansi.WithBackgroundColorErase(),
+			},
+			actions: []ansi.Action{
+				ansi.Print("some bytes"),
+				ansi.EraseLine(ansi.EraseToEnd),
+				ansi.SetBackground(ansi.Blue),
+				ansi.SetBold(true),
+				ansi.EraseLine(ansi.EraseToBeginning),
+				ansi.EraseLine(ansi.EraseAll),
+			},
+			printCalls: []printCall{
+				{
+					data: []byte("some bytes"),
+					pos:  ansi.Pos{Line: 0, Col: 0},
+				},
+				{
+					data:  bytes.Repeat([]byte{' '}, 10),
+					style: ansi.Style{Background: ansi.Blue},
+					pos:   ansi.Pos{Line: 0, Col: 0},
+				},
+				{
+					data:  bytes.Repeat([]byte{' '}, 12),
+					style: ansi.Style{Background: ansi.Blue},
+					pos:   ansi.Pos{Line: 0, Col: 0},
+				},
+			},
+			clearCalls: []clearCall{
+				{
+					pos: ansi.Pos{Line: 0, Col: 11},
+				},
+				{
+					pos: ansi.Pos{Line: 0, Col: 0},
+				},
+			},
+		},
+		{
+			description: "fixed width wraps prints at the last column",
+			opts:        []ansi.WriterOption{ansi.WithFixedWidth(5)},
+			actions: []ansi.Action{
+				ansi.Print("abcdefgh"),
+				ansi.Print("ij"),
+			},
+			printCalls: []printCall{
+				{
+					data: []byte("abcde"),
+					pos:  ansi.Pos{Line: 0, Col: 0},
+				},
+				{
+					data: []byte("fgh"),
+					pos:  ansi.Pos{Line: 1, Col: 0},
+				},
+				{
+					data: []byte("ij"),
+					pos:  ansi.Pos{Line: 1, Col: 3},
+				},
+			},
+			wrapCalls: []int{0},
+		},
+		{
+			description:    "fixed width only wraps once the next character is printed",
+			lineDiscipline: ansi.Cooked,
+			opts:           []ansi.WriterOption{ansi.WithFixedWidth(5)},
+			actions: []ansi.Action{
+				ansi.Print("abcde"),
+				ansi.CarriageReturn{},
+				ansi.Print("A"),
+				ansi.CursorColumn(4),
+				ansi.Print("E"),
+				ansi.Linebreak{},
+				ansi.Print("next"),
+			},
+			printCalls: []printCall{
+				{
+					data: []byte("abcde"),
+					pos:  ansi.Pos{Line: 0, Col: 0},
+				},
+				{
+					data: []byte("A"),
+					pos:  ansi.Pos{Line: 0, Col: 0},
+				},
+				{
+					data: []byte("E"),
+					pos:  ansi.Pos{Line: 0, Col: 4},
+				},
+				{
+					data: []byte("next"),
+					pos:  ansi.Pos{Line: 1, Col: 0},
+				},
+			},
+		},
+		{
+			description: "fixed width without autowrap overwrites the last column",
+			opts:        []ansi.WriterOption{ansi.WithFixedWidth(5)},
+			actions: []ansi.Action{
+				ansi.SetAutowrap(false),
+				ansi.Print("abcdefgh"),
+				ansi.Print("i"),
+				ansi.SetAutowrap(true),
+				ansi.Print("j"),
+			},
+			printCalls: []printCall{
+				{
+					data: []byte("abcde"),
+					pos:  ansi.Pos{Line: 0, Col: 0},
+				},
+				{
+					data: []byte("h"),
+					pos:  ansi.Pos{Line: 0, Col: 4},
+				},
+				{
+					data: []byte("i"),
+					pos:  ansi.Pos{Line: 0, Col: 4},
+				},
+				{
+					data: []byte("j"),
+					pos:  ansi.Pos{Line: 0, Col: 4},
+				},
+			},
+		},
+		{
+			description: "fixed width clamps the cursor to the last column",
+			opts:        []ansi.WriterOption{ansi.WithFixedWidth(5)},
+			actions: []ansi.Action{
+				ansi.CursorForward(1000),
+				ansi.Print("ab"),
+			},
+			printCalls: []printCall{
+				{
+					data: []byte("a"),
+					pos:  ansi.Pos{Line: 0, Col: 4},
+				},
+				{
+					data: []byte("b"),
+					pos:  ansi.Pos{Line: 1, Col: 0},
+				},
+			},
+			wrapCalls: []int{0},
+		},
+		{
+			description: "print calls move cursor by display width",
+			actions: []ansi.Action{
+				ansi.Print("日本"),
+				ansi.Print("e\u0301"),
+				ansi.Print("\u0301"),
+				ansi.Print("x"),
+			},
+			printCalls: []printCall{
+				{
+					data: []byte("日本"),
+					pos:  ansi.Pos{Line: 0, Col: 0},
+				},
+				{
+					data: []byte("e\u0301"),
+					pos:  ansi.Pos{Line: 0, Col: 4},
+				},
+				{
+					data: []byte("\u0301"),
+					pos:  ansi.Pos{Line: 0, Col: 5},
+				},
+				{
+					data: []byte("x"),
+					pos:  ansi.Pos{Line: 0, Col: 5},
+				},
+			},
+		},
+		{
+			description: "fixed width wraps wide characters that don't fit",
+			opts:        []ansi.WriterOption{ansi.WithFixedWidth(5)},
+			actions: []ansi.Action{
+				ansi.Print("a日本語"),
+				ansi.Print("\u0301"),
+			},
+			printCalls: []printCall{
+				{
+					data: []byte("a日本"),
+					pos:  ansi.Pos{Line: 0, Col: 0},
+				},
+				{
+					data: []byte("語"),
+					pos:  ansi.Pos{Line: 1, Col: 0},
+				},
+				{
+					data: []byte("\u0301"),
+					pos:  ansi.Pos{Line: 1, Col: 2},
+				},
+			},
+			wrapCalls: []int{0},
+		},
+		{
+			description: "combining characters don't trigger a pending wrap",
+			opts:        []ansi.WriterOption{ansi.WithFixedWidth(5)},
+			actions: []ansi.Action{
+				ansi.Print("abcde"),
+				ansi.Print("\u0301f"),
+			},
+			printCalls: []printCall{
+				{
+					data: []byte("abcde"),
+					pos:  ansi.Pos{Line: 0, Col: 0},
+				},
+				{
+					data: []byte("\u0301"),
+					pos:  ansi.Pos{Line: 0, Col: 5},
+				},
+				{
+					data: []byte("f"),
+					pos:  ansi.Pos{Line: 1, Col: 0},
+				},
+			},
+			wrapCalls: []int{0},
+		},
 	} {
 		t.Run(tt.description, func(t *testing.T) {
 			g := NewGomegaWithT(t)
 			spyOutput := &spyOutput{}
-			writer := ansi.NewWriter(spyOutput, ansi.WithLineDiscipline(tt.lineDiscipline))
+			opts := append([]ansi.WriterOption{ansi.WithLineDiscipline(tt.lineDiscipline)}, tt.opts...)
+			writer := ansi.NewWriter(spyOutput, opts...)
 
 			for _, act := range tt.actions {
 				writer.Action(act)
@@ -385,6 +695,1015 @@ func TestWriter_Action(t *testing.T) {
 
 			g.Expect(spyOutput.printCalls).To(Equal(tt.printCalls))
 			g.Expect(spyOutput.clearCalls).To(Equal(tt.clearCalls))
+			g.Expect(spyOutput.wrapCalls).To(Equal(tt.wrapCalls))
 		})
 	}
 }
+
+func repeatLine(line ansi.Line, n int) ansi.Lines {
+	lines := make(ansi.Lines, n)
+	for i := range lines {
+		lines[i] = line
+	}
+	return lines
+}
+
+func TestWriter_Resize(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		opts        []ansi.WriterOption
+		input       string
+		lines       int
+		cols        int
+		then        string
+		state       ansi.State
+		output      ansi.Lines
+	}{
+		{
+			description: "changes the screen size",
+			input:       "hello",
+			lines:       24,
+			cols:        132,
+			state: ansi.State{
+				Position: ansi.Pos{Line: 0, Col: 5},
+				MaxLine:  24,
+				MaxCol:   132,
+				Height:   24,
+			},
+			output: ansi.Lines{{{Data: ansi.Text("hello")}}},
+		},
+		{
+			description: "keeps the cursor on its line",
+			input:       "\n\n\n\nhello",
+			lines:       2,
+			state: ansi.State{
+				Position: ansi.Pos{Line: 4, Col: 5},
+				MaxLine:  4,
+				MaxCol:   80,
+				Height:   2,
+			},
+			output: ansi.Lines{{}, {}, {}, {}, {{Data: ansi.Text("hello")}}},
+		},
+		{
+			description: "keeps the lines output below the new height within reach",
+			input:       strings.Repeat("x\n", 100) + "\x1b[10;0H",
+			lines:       24,
+			then:        "\x1b[200B",
+			state: ansi.State{
+				Position: ansi.Pos{Line: 100, Col: 0},
+				MaxLine:  100,
+				MaxCol:   80,
+				Height:   24,
+			},
+			output: repeatLine(ansi.Line{{Data: ansi.Text("x")}}, 100),
+		},
+		{
+			description: "moves the bottom of the screen along with its height",
+			input:       strings.Repeat("x\n", 100),
+			lines:       60,
+			then:        "\x1b[200B",
+			state: ansi.State{
+				Position: ansi.Pos{Line: 112, Col: 0},
+				MaxLine:  112,
+				MaxCol:   80,
+				Height:   60,
+			},
+			output: repeatLine(ansi.Line{{Data: ansi.Text("x")}}, 100),
+		},
+		{
+			description: "clamps the cursor to the new width",
+			opts:        []ansi.WriterOption{ansi.WithFixedWidth(10)},
+			input:       "hello",
+			cols:        3,
+			state: ansi.State{
+				Position:   ansi.Pos{Line: 0, Col: 2},
+				MaxLine:    48,
+				MaxCol:     3,
+				FixedWidth: true,
+				Height:     48,
+			},
+			output: ansi.Lines{{{Data: ansi.Text("hello")}}},
+		},
+		{
+			description: "drops a pending wrap once the line is wide enough",
+			opts:        []ansi.WriterOption{ansi.WithFixedWidth(5)},
+			input:       "hello",
+			cols:        10,
+			state: ansi.State{
+				Position:   ansi.Pos{Line: 0, Col: 5},
+				MaxLine:    48,
+				MaxCol:     10,
+				FixedWidth: true,
+				Height:     48,
+			},
+			output: ansi.Lines{{{Data: ansi.Text("hello")}}},
+		},
+		{
+			description: "reflows soft-wrapped lines",
+			opts:        []ansi.WriterOption{ansi.WithFixedWidth(5), ansi.WithReflow()},
+			input:       "hello world\nbye",
+			cols:        20,
+			state: ansi.State{
+				Position:   ansi.Pos{Line: 1, Col: 3},
+				MaxLine:    46,
+				MaxCol:     20,
+				FixedWidth: true,
+				Height:     48,
+			},
+			output: ansi.Lines{
+				{{Data: ansi.Text("hello world")}},
+				{{Data: ansi.Text("bye")}},
+			},
+		},
+		{
+			description: "reflowing to the end of a full line leaves a pending wrap",
+			opts:        []ansi.WriterOption{ansi.WithFixedWidth(5), ansi.WithReflow()},
+			input:       "hello wor",
+			cols:        3,
+			state: ansi.State{
+				Position:    ansi.Pos{Line: 2, Col: 2},
+				MaxLine:     49,
+				MaxCol:      3,
+				FixedWidth:  true,
+				Height:      48,
+				PendingWrap: true,
+			},
+			output: ansi.Lines{
+				{{Data: ansi.Text("hel"), Wrapped: true}},
+				{{Data: ansi.Text("lo "), Wrapped: true}},
+				{{Data: ansi.Text("wor")}},
+			},
+		},
+		{
+			description: "resizes the main screen while the alternate one is active",
+			opts:        []ansi.WriterOption{ansi.WithFixedWidth(10)},
+			input:       "\n\n\nhello\x1b[?1049h",
+			lines:       2,
+			cols:        3,
+			then:        "\x1b[?1049l",
+			state: ansi.State{
+				Position:      ansi.Pos{Line: 3, Col: 2},
+				SavedCursor:   &ansi.SavedCursor{Position: ansi.Pos{Line: 3, Col: 5}},
+				SavedPosition: &ansi.Pos{Line: 3, Col: 5},
+				MaxLine:       3,
+				MaxCol:        3,
+				FixedWidth:    true,
+				Height:        2,
+			},
+			output: ansi.Lines{{}, {}, {}, {{Data: ansi.Text("hello")}}},
+		},
+		{
+			description: "reflows the main screen while the alternate one is active",
+			opts:        []ansi.WriterOption{ansi.WithFixedWidth(5), ansi.WithReflow()},
+			input:       "hello world\x1b[?1049h",
+			cols:        20,
+			then:        "\x1b[?1049l",
+			state: ansi.State{
+				Position:      ansi.Pos{Line: 0, Col: 11},
+				SavedCursor:   &ansi.SavedCursor{Position: ansi.Pos{Line: 0, Col: 11}},
+				SavedPosition: &ansi.Pos{Line: 0, Col: 11},
+				MaxLine:       46,
+				MaxCol:        20,
+				FixedWidth:    true,
+				Height:        48,
+			},
+			output: ansi.Lines{{{Data: ansi.Text("hello world")}}},
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			var lines ansi.Lines
+			writer := ansi.NewWriter(&lines, tt.opts...)
+			writer.Write([]byte(tt.input))
+
+			g.Expect(writer.Resize(tt.lines, tt.cols)).To(Succeed())
+			writer.Write([]byte(tt.then))
+
+			tt.state.LineDiscipline = ansi.Cooked
+			tt.state.Autowrap = true
+			g.Expect(writer.State).To(Equal(tt.state))
+			g.Expect(lines).To(Equal(tt.output))
+		})
+	}
+}
+
+func TestWriter_SavedPosition(t *testing.T) {
+	g := NewGomegaWithT(t)
+	var lines ansi.Lines
+	writer := ansi.NewWriter(&lines)
+
+	_, err := writer.WriteString("hello\x1b7\n")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(writer.SavedPosition).To(Equal(&ansi.Pos{Line: 0, Col: 5}))
+	g.Expect(writer.SavedCursor.Position).To(Equal(ansi.Pos{Line: 0, Col: 5}))
+
+	// Callers setting it directly still get the cursor moved there
+	writer.SavedCursor = nil
+	writer.SavedPosition = &ansi.Pos{Line: 0, Col: 2}
+	_, err = writer.WriteString("\x1b8")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(writer.Position).To(Equal(ansi.Pos{Line: 0, Col: 2}))
+
+	_, err = writer.WriteString("\x1b[!p")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(writer.SavedPosition).To(BeNil())
+}
+
+func TestWriter_AltScreen(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		policy      ansi.AltScreenPolicy
+		input       string
+		lines       ansi.Lines
+		snapshots   []ansi.Lines
+	}{
+		{
+			description: "output on the alternate screen is discarded",
+			input:       "$ top\n\x1b[?1049h\x1b[0;0H\x1b[2Jtop - 10:00\x1b[31m\n  PID\x1b[?1049lbye",
+			lines: ansi.Lines{
+				{{Data: ansi.Text("$ top")}},
+				{{Data: ansi.Text("bye")}},
+			},
+		},
+		{
+			description: "the final frame can be kept as a snapshot",
+			policy:      ansi.SnapshotAltScreen,
+			input:       "$ top\n\x1b[?1049hframe 1\x1b[0;0H\x1b[2Jframe 2\x1b[?1049lbye",
+			lines: ansi.Lines{
+				{{Data: ansi.Text("$ top")}},
+				{{Data: ansi.Text("bye")}},
+			},
+			snapshots: []ansi.Lines{
+				{{{Data: ansi.Text("frame 2")}}},
+			},
+		},
+		{
+			description: "the final frame can be appended to the main screen",
+			policy:      ansi.AppendAltScreen,
+			input:       "$ top\x1b[?1049h\x1b[0;0H\x1b[2Jframe 1\x1b[0;0H\x1b[2J\x1b[1mframe 2\n\n\x1b[?1049ldone",
+			lines: ansi.Lines{
+				{{Data: ansi.Text("$ top")}},
+				{{Data: ansi.Text("frame 2"), Style: ansi.Style{Modifier: ansi.Bold}}},
+				{{Data: ansi.Text("done")}},
+			},
+		},
+		{
+			description: "the alternate screen has its own saved cursor",
+			input:       "\x1b[1mbold\x1b7\x1b[m\x1b[?47h\x1b[31m\x1b7\x1b[?47l\x1b8 again",
+			lines: ansi.Lines{
+				{
+					{Data: ansi.Text("bold again"), Style: ansi.Style{Modifier: ansi.Bold}},
+				},
+			},
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			var lines ansi.Lines
+			writer := ansi.NewWriter(&lines, ansi.WithAltScreenPolicy(tt.policy))
+			_, err := writer.Write([]byte(tt.input))
+			g.Expect(err).ToNot(HaveOccurred())
+
+			g.Expect(writer.AltScreen).To(BeFalse())
+			g.Expect(lines).To(Equal(tt.lines))
+			g.Expect(writer.AltScreenSnapshots).To(Equal(tt.snapshots))
+		})
+	}
+}
+
+func TestWriter_AppendAltScreenChunks(t *testing.T) {
+	g := NewGomegaWithT(t)
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var lines ansi.Lines
+	writer := ansi.NewWriter(&lines,
+		ansi.WithAltScreenPolicy(ansi.AppendAltScreen),
+		ansi.WithTimestamps(func() time.Time { return start }, 0))
+	stderr := writer.Stream("stderr")
+
+	_, err := writer.WriteString("\x1b[?1049h")
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = stderr.WriteString("err\x1b]1337;File=inline=1:aGk=\x07")
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = writer.WriteString("\x1b[?1049l")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Expect(lines).To(Equal(ansi.Lines{
+		{
+			{Data: ansi.Text("err"), Stream: "stderr", Time: &start},
+			{
+				Data:   ansi.Text("\uFFFC"),
+				Image:  &ansi.Image{Protocol: ansi.ITermImages, Data: []byte("hi")},
+				Stream: "stderr",
+				Time:   &start,
+			},
+		},
+	}))
+}
+
+func TestWriter_Responses(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		opts        []ansi.WriterOption
+		input       string
+		responses   string
+	}{
+		{
+			description: "answers device status reports",
+			input:       "\x1b[5n",
+			responses:   "\x1b[0n",
+		},
+		{
+			description: "reports the cursor position 1-based",
+			input:       "\nhello\x1b[6n\x1b[0;0H\x1b[6n",
+			responses:   "\x1b[2;6R\x1b[1;1R",
+		},
+		{
+			description: "reports the cursor position 1-based whatever the profile",
+			opts:        []ansi.WriterOption{ansi.WithCompatibility(ansi.XTerm)},
+			input:       "\nhello\x1b[6n\x1b[1;1H\x1b[6n",
+			responses:   "\x1b[2;6R\x1b[1;1R",
+		},
+		{
+			description: "reports the cursor row relative to the top of the screen",
+			opts:        []ansi.WriterOption{ansi.WithInitialScreenSize(24, 80)},
+			input:       strings.Repeat("\n", 100) + "\x1b[3A\x1b[6n",
+			responses:   "\x1b[22;1R",
+		},
+		{
+			description: "reports the cursor row on the alternate screen",
+			opts:        []ansi.WriterOption{ansi.WithInitialScreenSize(24, 80)},
+			input:       strings.Repeat("\n", 100) + "\x1b[?1049h\x1b[5;3H\x1b[6n",
+			responses:   "\x1b[6;4R",
+		},
+		{
+			description: "answers device attributes",
+			input:       "\x1b[c\x1b[>c",
+			responses:   "\x1b[?62;22c\x1b[>1;10;0c",
+		},
+		{
+			description: "answers colour queries",
+			input:       "\x1b]10;?\x07\x1b]11;?\x1b\\\x1b]4;1;?\x07",
+			responses: "\x1b]10;rgb:eeee/eeee/eeee\x1b\\" +
+				"\x1b]11;rgb:0000/0000/0000\x1b\\" +
+				"\x1b]4;1;rgb:cdcd/0000/0000\x1b\\",
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			var lines ansi.Lines
+			var responses bytes.Buffer
+			opts := append([]ansi.WriterOption{ansi.WithResponses(&responses)}, tt.opts...)
+			writer := ansi.NewWriter(&lines, opts...)
+			_, err := writer.Write([]byte(tt.input))
+			g.Expect(err).ToNot(HaveOccurred())
+
+			g.Expect(responses.String()).To(Equal(tt.responses))
+		})
+	}
+}
+
+func TestWriter_Titles(t *testing.T) {
+	g := NewGomegaWithT(t)
+	var lines ansi.Lines
+	writer := ansi.NewWriter(&lines)
+	_, err := writer.Write([]byte("\x1b]0;build\x07compiling\n\x1b]2;build\x07" +
+		"\x1b]7;file://host/src\x07\x1b]2;test\x07testing\n\n" +
+		"\x1b[?1049h\n\n\x1b]2;top\x07\x1b[?1049l"))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Expect(writer.WindowTitle).To(Equal("top"))
+	g.Expect(writer.IconName).To(Equal("build"))
+	g.Expect(writer.WorkingDirectory).To(Equal("/src"))
+	g.Expect(writer.TitleHistory).To(Equal([]ansi.TitleChange{
+		{Line: 0, Title: "build"},
+		{Line: 1, Title: "test"},
+		{Line: 3, Title: "top"},
+	}))
+}
+
+func TestWriter_Commands(t *testing.T) {
+	g := NewGomegaWithT(t)
+	var lines ansi.Lines
+	writer := ansi.NewWriter(&lines)
+	prompt := "\x1b]133;A\x07$ \x1b]133;B\x07"
+	_, err := writer.Write([]byte(prompt + "ls\n\x1b]133;C\x07a\nb\n\x1b]133;D;0\x07" +
+		prompt + "false\n\x1b]133;C\x07\x1b]133;D;1\x07" +
+		prompt + "sleep 10"))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Expect(writer.Commands).To(Equal([]ansi.CommandSection{
+		{
+			PromptLine:  0,
+			CommandLine: 0,
+			OutputLine:  1,
+			EndLine:     3,
+			Command:     "ls",
+			Finished:    true,
+			ExitCode:    0,
+			HasExitCode: true,
+		},
+		{
+			PromptLine:  3,
+			CommandLine: 3,
+			OutputLine:  4,
+			EndLine:     4,
+			Command:     "false",
+			Finished:    true,
+			ExitCode:    1,
+			HasExitCode: true,
+		},
+		{
+			PromptLine:  4,
+			CommandLine: 4,
+			OutputLine:  4,
+			EndLine:     4,
+		},
+	}))
+}
+
+func TestWriter_CommandTooLong(t *testing.T) {
+	g := NewGomegaWithT(t)
+	var lines ansi.Lines
+	writer := ansi.NewWriter(&lines)
+	_, err := writer.WriteString("\x1b]133;A\x07$ \x1b]133;B\x07")
+	g.Expect(err).ToNot(HaveOccurred())
+	long := strings.Repeat("x", 1<<20)
+	for i := 0; i < 17; i++ {
+		_, err = writer.WriteString(long)
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+	_, err = writer.WriteString("\n\x1b]133;C\x07")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Expect(writer.Commands).To(HaveLen(1))
+	g.Expect(writer.Commands[0].Command).To(HaveLen(1 << 24))
+}
+
+func TestWriter_Groups(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		detector    ansi.GroupDetector
+		input       string
+		lines       ansi.Lines
+		groups      []*ansi.Group
+	}{
+		{
+			description: "strips markers and records groups",
+			detector:    ansi.GitHubGroups,
+			input:       "start\n::group::Build\r\ncompiling\n::endgroup::\ndone\n",
+			lines: ansi.Lines{
+				{{Data: ansi.Text("start")}},
+				{{Data: ansi.Text("compiling")}},
+				{{Data: ansi.Text("done")}},
+			},
+			groups: []*ansi.Group{
+				{Title: "Build", StartLine: 1, EndLine: 2},
+			},
+		},
+		{
+			description: "groups can be nested and left open",
+			detector:    ansi.GitHubGroups,
+			input:       "::group::Outer\n::group::Inner\na\n::endgroup::\nb\n::endgroup::\n::endgroup::\n::group::Last\nc",
+			lines: ansi.Lines{
+				{{Data: ansi.Text("a")}},
+				{{Data: ansi.Text("b")}},
+				{{Data: ansi.Text("c")}},
+			},
+			groups: []*ansi.Group{
+				{
+					Title:     "Outer",
+					StartLine: 0,
+					EndLine:   2,
+					Groups: []*ansi.Group{
+						{Title: "Inner", StartLine: 0, EndLine: 1},
+					},
+				},
+				{Title: "Last", StartLine: 2, Open: true},
+			},
+		},
+		{
+			description: "lines that were redrawn aren't markers",
+			detector:    ansi.GitHubGroups,
+			input:       "::group::\x1b[3Dno\n",
+			lines: ansi.Lines{
+				{{Data: ansi.Text("::grouno:")}},
+			},
+		},
+		{
+			description: "markers can be operating system commands",
+			detector: ansi.PatternGroupDetector{
+				Start: regexp.MustCompile(`^start=(.*)$`),
+				End:   regexp.MustCompile(`^end$`),
+				OSC:   6000,
+			},
+			input: "\x1b]6000;start=Tests\x07ok\n\x1b]6000;end\x07",
+			lines: ansi.Lines{
+				{{Data: ansi.Text("ok")}},
+			},
+			groups: []*ansi.Group{
+				{Title: "Tests", StartLine: 0, EndLine: 1},
+			},
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			var lines ansi.Lines
+			writer := ansi.NewWriter(&lines, ansi.WithGroupDetector(tt.detector))
+			_, err := writer.Write([]byte(tt.input))
+			g.Expect(err).ToNot(HaveOccurred())
+
+			g.Expect(lines).To(Equal(tt.lines))
+			g.Expect(writer.Groups).To(Equal(tt.groups))
+		})
+	}
+}
+
+func TestWriter_Notifications(t *testing.T) {
+	g := NewGomegaWithT(t)
+	var (
+		lines         ansi.Lines
+		notifications []ansi.Notification
+	)
+	writer := ansi.NewWriter(&lines, ansi.WithNotificationHandler(func(n ansi.Notification) {
+		notifications = append(notifications, n)
+	}))
+	_, err := writer.Write([]byte("compiling\x07\n\n\x1b]777;notify;CI;Tests failed\x07"))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Expect(lines).To(Equal(ansi.Lines{{{Data: ansi.Text("compiling")}}}))
+	g.Expect(notifications).To(Equal([]ansi.Notification{
+		{Line: 0, Bell: true},
+		{Line: 2, Title: "CI", Body: "Tests failed"},
+	}))
+}
+
+func TestWriter_Reset(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		opts        []ansi.WriterOption
+		input       string
+		state       ansi.State
+		lines       ansi.Lines
+	}{
+		{
+			description: "soft reset keeps the cursor where it is",
+			opts:        []ansi.WriterOption{ansi.WithFixedWidth(10)},
+			input:       "\x1b[1m\x1b(0\x1b[?7l\x1b7ab\x1b[!pq",
+			state: ansi.State{
+				LineDiscipline: ansi.Cooked,
+				Position:       ansi.Pos{Line: 0, Col: 3},
+				MaxLine:        48,
+				MaxCol:         10,
+				Height:         48,
+				FixedWidth:     true,
+				Autowrap:       true,
+			},
+			lines: ansi.Lines{
+				{
+					{Data: ansi.Text("▒␉"), Style: ansi.Style{Modifier: ansi.Bold}},
+					{Data: ansi.Text("q")},
+				},
+			},
+		},
+		{
+			description: "full reset carries on below the output",
+			input:       "\x1b[31m$ reset\x1b[?1049hmenu\x1bcdone",
+			state: ansi.State{
+				LineDiscipline: ansi.Cooked,
+				Position:       ansi.Pos{Line: 1, Col: 4},
+				MaxLine:        48,
+				MaxCol:         80,
+				Height:         48,
+				Autowrap:       true,
+			},
+			lines: ansi.Lines{
+				{{Data: ansi.Text("$ reset"), Style: ansi.Style{Foreground: ansi.Red}}},
+				{{Data: ansi.Text("done")}},
+			},
+		},
+		{
+			description: "full reset throws away the alternate screen",
+			opts:        []ansi.WriterOption{ansi.WithAltScreenPolicy(ansi.AppendAltScreen)},
+			input:       "$ top\x1b[?1049h\x1b[Hhalf a frame\x1bcdone",
+			state: ansi.State{
+				LineDiscipline: ansi.Cooked,
+				Position:       ansi.Pos{Line: 1, Col: 4},
+				MaxLine:        48,
+				MaxCol:         80,
+				Height:         48,
+				Autowrap:       true,
+			},
+			lines: ansi.Lines{
+				{{Data: ansi.Text("$ top")}},
+				{{Data: ansi.Text("done")}},
+			},
+		},
+		{
+			description: "full reset can clear the screen",
+			opts: []ansi.WriterOption{
+				ansi.WithInitialScreenSize(2, 80),
+				ansi.WithClearOnReset(),
+			},
+			input: "one\ntwo\nthree\nfour\x1bcdone",
+			state: ansi.State{
+				LineDiscipline: ansi.Cooked,
+				Position:       ansi.Pos{Line: 1, Col: 4},
+				MaxLine:        3,
+				MaxCol:         80,
+				Height:         2,
+				Autowrap:       true,
+			},
+			lines: ansi.Lines{
+				{{Data: ansi.Text("one")}},
+				{{Data: ansi.Text("done")}},
+				{},
+				{},
+			},
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			var lines ansi.Lines
+			writer := ansi.NewWriter(&lines, tt.opts...)
+			_, err := writer.Write([]byte(tt.input))
+			g.Expect(err).ToNot(HaveOccurred())
+
+			g.Expect(writer.State).To(Equal(tt.state))
+			g.Expect(lines).To(Equal(tt.lines))
+		})
+	}
+}
+
+func TestWriter_ResetLineDiscipline(t *testing.T) {
+	g := NewGomegaWithT(t)
+	var lines ansi.Lines
+	writer := ansi.NewWriter(&lines, ansi.WithLineDiscipline(ansi.Raw))
+
+	// LNM survives a soft reset
+	writer.LineDiscipline = ansi.Cooked
+	_, err := writer.WriteString("\x1b[!pone\ntwo")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(writer.LineDiscipline).To(Equal(ansi.Cooked))
+
+	// but not a full one
+	_, err = writer.WriteString("\x1bcthree\nfour")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(writer.LineDiscipline).To(Equal(ansi.Raw))
+
+	g.Expect(lines).To(Equal(ansi.Lines{
+		{{Data: ansi.Text("one")}},
+		{{Data: ansi.Text("two")}},
+		{{Data: ansi.Text("three")}},
+		{{Data: ansi.Text("     four")}},
+	}))
+}
+
+func TestWriter_IO(t *testing.T) {
+	g := NewGomegaWithT(t)
+	var lines ansi.Lines
+	writer := ansi.NewWriter(&lines)
+
+	var _ io.Writer = writer
+	var _ io.StringWriter = writer
+	var _ io.ReaderFrom = writer
+	var _ io.Closer = writer
+
+	n, err := io.Copy(writer, iotest.OneByteReader(strings.NewReader("h\xc3\xa9\x1b[1mllo\n")))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(n).To(Equal(int64(11)))
+	_, err = writer.WriteString("wörld")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(writer.Close()).To(Succeed())
+
+	g.Expect(lines).To(Equal(ansi.Lines{
+		{
+			{Data: ansi.Text("hé")},
+			{Data: ansi.Text("llo"), Style: ansi.Style{Modifier: ansi.Bold}},
+		},
+		{{Data: ansi.Text("wörld"), Style: ansi.Style{Modifier: ansi.Bold}}},
+	}))
+
+	_, err = writer.Write([]byte("more"))
+	g.Expect(err).To(Equal(ansi.ErrClosed))
+}
+
+func TestWriter_Flush(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		policy      ansi.UnfinishedPolicy
+		input       string
+		err         error
+		lines       ansi.Lines
+	}{
+		{
+			description: "unfinished input is dropped by default",
+			input:       "hello\x1b[3",
+			lines:       ansi.Lines{{{Data: ansi.Text("hello")}}},
+		},
+		{
+			description: "unfinished input can be printed",
+			policy:      ansi.PrintUnfinished,
+			input:       "hello\x1b[3",
+			lines:       ansi.Lines{{{Data: ansi.Text("hello\x1b[3")}}},
+		},
+		{
+			description: "unfinished input can be reported",
+			policy:      ansi.ReportUnfinished,
+			input:       "hello\xc3",
+			err:         ansi.ErrUnfinished,
+			lines:       ansi.Lines{{{Data: ansi.Text("hello")}}},
+		},
+		{
+			description: "finished input is never reported",
+			policy:      ansi.ReportUnfinished,
+			input:       "hello\x1b[m",
+			lines:       ansi.Lines{{{Data: ansi.Text("hello")}}},
+		},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			var lines ansi.Lines
+			writer := ansi.NewWriter(&lines, ansi.WithUnfinishedPolicy(tt.policy))
+			_, err := writer.Write([]byte(tt.input))
+			g.Expect(err).ToNot(HaveOccurred())
+
+			err = writer.Flush()
+			if tt.err != nil {
+				g.Expect(err).To(Equal(tt.err))
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+			g.Expect(lines).To(Equal(tt.lines))
+		})
+	}
+}
+
+type commitOutput struct {
+	ansi.Lines
+	commits []int
+	// text is what each committed line held when it was committed
+	text []string
+}
+
+func (o *commitOutput) CommitLine(line int) error {
+	o.commits = append(o.commits, line)
+	var text string
+	if line < len(o.Lines) {
+		for _, chunk := range o.Lines[line] {
+			text += string(chunk.Data)
+		}
+	}
+	o.text = append(o.text, text)
+	return nil
+}
+
+func TestWriter_Commits(t *testing.T) {
+	g := NewGomegaWithT(t)
+	output := &commitOutput{}
+	writer := ansi.NewWriter(output, ansi.WithInitialScreenSize(3, 80))
+
+	_, err := writer.WriteString("a\nb\nc\n")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(output.commits).To(BeEmpty())
+
+	_, err = writer.WriteString("d\ne")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(output.commits).To(Equal([]int{0}))
+
+	// Committed lines are out of the cursor's reach
+	_, err = writer.WriteString("\x1b[0;0Hx\x1b[5Ay")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Nothing gets committed while the alternate screen is active
+	_, err = writer.WriteString("\x1b[?1049h\n\n\n\n\n\n")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(output.commits).To(Equal([]int{0}))
+
+	g.Expect(writer.Close()).To(Succeed())
+	g.Expect(output.commits).To(Equal([]int{0, 1, 2, 3, 4}))
+	g.Expect(output.Lines).To(Equal(ansi.Lines{
+		{{Data: ansi.Text("a")}},
+		{{Data: ansi.Text("xy")}},
+		{{Data: ansi.Text("c")}},
+		{{Data: ansi.Text("d")}},
+		{{Data: ansi.Text("e")}},
+	}))
+}
+
+func TestWriter_CommitsReflow(t *testing.T) {
+	g := NewGomegaWithT(t)
+	output := &commitOutput{}
+	writer := ansi.NewWriter(output,
+		ansi.WithInitialScreenSize(2, 4),
+		ansi.WithFixedWidth(4),
+		ansi.WithReflow())
+
+	_, err := writer.WriteString("aaaa\nbbbb\ncccc\ndd")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(output.commits).To(Equal([]int{0}))
+
+	// The committed line now takes up lines 0 and 1, neither of which gets
+	// committed again
+	g.Expect(writer.Resize(0, 2)).To(Succeed())
+	g.Expect(writer.Close()).To(Succeed())
+	g.Expect(output.commits).To(Equal([]int{0, 2, 3, 4, 5, 6}))
+	g.Expect(output.text).To(Equal([]string{"aaaa", "bb", "bb", "cc", "cc", "dd"}))
+}
+
+func TestSyncWriter(t *testing.T) {
+	g := NewGomegaWithT(t)
+	writer := ansi.NewSyncWriter()
+
+	_, err := writer.WriteString("one\ntwo")
+	g.Expect(err).ToNot(HaveOccurred())
+	snapshot := writer.Snapshot()
+	g.Expect(writer.Snapshot()).To(BeIdenticalTo(snapshot))
+
+	// Later writes leave the snapshot alone
+	_, err = writer.WriteString("\r\x1b[1mTWO\x1b[m\n\x1b[2Aone!")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(snapshot.Lines).To(Equal(ansi.Lines{
+		{{Data: ansi.Text("one")}},
+		{{Data: ansi.Text("two")}},
+	}))
+	g.Expect(snapshot.State.Position).To(Equal(ansi.Pos{Line: 1, Col: 3}))
+	g.Expect(writer.Snapshot().Version).To(BeNumerically(">", snapshot.Version))
+	g.Expect(writer.Snapshot().Lines).To(Equal(ansi.Lines{
+		{{Data: ansi.Text("one!")}},
+		{{Data: ansi.Text("TWO"), Style: ansi.Style{Modifier: ansi.Bold}}},
+	}))
+}
+
+func TestSyncWriter_Concurrent(t *testing.T) {
+	const writes = 2000
+	writer := ansi.NewSyncWriter()
+	base := writer.Snapshot().Version
+	text := func(snapshot *ansi.Snapshot) string {
+		var s string
+		for _, line := range snapshot.Lines {
+			for _, chunk := range line {
+				s += string(chunk.Data)
+			}
+			s += "|"
+		}
+		return s
+	}
+	// Every write rewrites the whole line, half of it in bold
+	expected := func(version int) string {
+		n := version - base
+		if n == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%04d|", n-1)
+	}
+
+	done := make(chan struct{})
+	var started, wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		started.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var kept []*ansi.Snapshot
+			for first := true; ; first = false {
+				select {
+				case <-done:
+					for _, snapshot := range kept {
+						if got := text(snapshot); got != expected(snapshot.Version) {
+							t.Errorf("snapshot %d changed to %q", snapshot.Version, got)
+						}
+					}
+					return
+				default:
+				}
+				snapshot := writer.Snapshot()
+				if got := text(snapshot); got != expected(snapshot.Version) {
+					t.Errorf("snapshot %d is %q", snapshot.Version, got)
+				}
+				if len(kept) < 100 {
+					kept = append(kept, snapshot)
+				}
+				if first {
+					started.Done()
+				}
+			}
+		}()
+	}
+	started.Wait()
+
+	for i := 0; i < writes; i++ {
+		n := fmt.Sprintf("%04d", i)
+		if _, err := fmt.Fprintf(writer, "\r%s\x1b[1m%s\x1b[m", n[:2], n[2:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestWriter_Streams(t *testing.T) {
+	g := NewGomegaWithT(t)
+	var lines ansi.Lines
+	writer := ansi.NewWriter(&lines, ansi.WithUnfinishedPolicy(ansi.PrintUnfinished))
+	stdout := writer.Stream("stdout")
+	stderr := writer.Stream("stderr")
+	g.Expect(writer.Stream("stdout")).To(BeIdenticalTo(stdout))
+
+	// Unfinished sequences in one stream don't swallow the other's output
+	_, err := stdout.WriteString("\x1b[3")
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = stderr.WriteString("oops \xe2\x9c")
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = stdout.WriteString("1mok\x1b[m\n")
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = stderr.WriteString("\x97\n")
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = writer.WriteString("done\n")
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = stdout.WriteString("\x1b[")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(writer.Close()).To(Succeed())
+
+	g.Expect(lines).To(Equal(ansi.Lines{
+		{
+			{Data: ansi.Text("oops "), Stream: "stderr"},
+			{Data: ansi.Text("ok"), Style: ansi.Style{Foreground: ansi.Red}, Stream: "stdout"},
+		},
+		{{Data: ansi.Text("✗"), Stream: "stderr"}},
+		{{Data: ansi.Text("done")}},
+		{{Data: ansi.Text("\x1b["), Stream: "stdout"}},
+	}))
+
+	marshalled, err := json.Marshal(lines[1])
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(marshalled).To(MatchJSON(`[{"data": "✗", "style": {}, "stream": "stderr"}]`))
+}
+
+func TestSyncWriter_Streams(t *testing.T) {
+	g := NewGomegaWithT(t)
+	writer := ansi.NewSyncWriter()
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"stdout", "stderr"} {
+		wg.Add(1)
+		go func(stream io.Writer) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				// Split so that the sequence is often interrupted
+				io.WriteString(stream, "\x1b[1")
+				io.WriteString(stream, "mx\x1b[m\n")
+			}
+		}(writer.Stream(name))
+	}
+	wg.Wait()
+
+	lines := writer.Snapshot().Lines
+	g.Expect(lines).To(HaveLen(200))
+	for _, line := range lines {
+		g.Expect(line).To(HaveLen(1))
+		g.Expect(line[0].Data).To(Equal(ansi.Text("x")))
+		g.Expect(line[0].Style.Modifier).To(Equal(ansi.Bold))
+	}
+}
+
+func TestWriter_Timestamps(t *testing.T) {
+	g := NewGomegaWithT(t)
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := start.Add(d)
+		return &t
+	}
+	now := start
+	var lines ansi.Lines
+	writer := ansi.NewWriter(&lines, ansi.WithTimestamps(func() time.Time { return now }, time.Second))
+
+	_, err := writer.WriteString("one")
+	g.Expect(err).ToNot(HaveOccurred())
+	now = start.Add(500 * time.Millisecond)
+	_, err = writer.WriteString("!")
+	g.Expect(err).ToNot(HaveOccurred())
+	now = start.Add(2 * time.Second)
+	_, err = writer.WriteString("\ntwo")
+	g.Expect(err).ToNot(HaveOccurred())
+	now = start.Add(3 * time.Second)
+	_, err = writer.WriteString("\x1b[1A\rO")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Expect(lines).To(Equal(ansi.Lines{
+		{
+			{Data: ansi.Text("O"), Time: at(3 * time.Second)},
+			{Data: ansi.Text("ne!"), Time: at(0)},
+		},
+		{{Data: ansi.Text("two"), Time: at(2 * time.Second)}},
+	}))
+	g.Expect(ansi.LineTime(lines[0])).To(Equal(start))
+	g.Expect(ansi.LineTime(lines[1])).To(Equal(start.Add(2 * time.Second)))
+	g.Expect(ansi.LineTime(ansi.Line{{Data: ansi.Text("untimed")}}).IsZero()).To(BeTrue())
+
+	marshalled, err := json.Marshal(lines[1])
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(marshalled).To(MatchJSON(`[{"data": "two", "style": {}, "time": "2020-01-02T03:04:07Z"}]`))
+	var unmarshalled ansi.Line
+	g.Expect(json.Unmarshal(marshalled, &unmarshalled)).To(Succeed())
+	g.Expect(unmarshalled).To(Equal(lines[1]))
+}